@@ -2,12 +2,14 @@ package azblob
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/url"
 
 	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
@@ -15,6 +17,7 @@ import (
 type AzBlockBlob struct {
 	ctx          context.Context
 	URL          *url.URL
+	ETag         string
 	credential   azblob.Credential
 	blockBlobURL *azblob.BlockBlobURL
 
@@ -124,7 +127,12 @@ func (s *AzBlockBlob) Read(p []byte) (n int, err error) {
 	}
 
 	count := int64(len(p))
-	resp, err := s.blockBlobURL.Download(s.ctx, s.offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	ctx, span := trace.Start(s.ctx, "azblob.Download", s.attributes(trace.String(trace.AttrRange, rangeAttribute(s.offset, count)))...)
+	defer func() {
+		span.SetAttributes(trace.Int64(trace.AttrBytes, int64(n)))
+		span.End(err)
+	}()
+	resp, err := s.blockBlobURL.Download(ctx, s.offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
 	if err != nil {
 		return 0, errors.Wrap(err, "s.blockBlobURL.Download")
 	}
@@ -148,6 +156,71 @@ func (s *AzBlockBlob) Read(p []byte) (n int, err error) {
 	return bytesRead, nil
 }
 
+// ReadAt reads len(p) bytes starting at off without moving the offset used
+// by Read, it is safe for concurrent use
+func (s *AzBlockBlob) ReadAt(p []byte, off int64) (int, error) {
+	if s.blockBlobURL == nil {
+		return 0, errors.Wrap(errReadNotOpened, "errReadNotOpened")
+	}
+	if off < 0 {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	if len(p) == 0 {
+		// a count of 0 would download the whole blob
+		return 0, nil
+	}
+	if s.fileSize >= 0 && off >= s.fileSize {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	count := int64(len(p))
+	if s.fileSize >= 0 && off+count > s.fileSize {
+		count = s.fileSize - off
+	}
+	// fail instead of mixing versions, see CacheKey
+	conditions := azblob.BlobAccessConditions{}
+	if s.ETag != "" {
+		conditions.ModifiedAccessConditions.IfMatch = azblob.ETag(s.ETag)
+	}
+	ctx, span := trace.Start(s.ctx, "azblob.Download", s.attributes(trace.String(trace.AttrRange, rangeAttribute(off, count)))...)
+	resp, err := s.blockBlobURL.Download(ctx, off, count, conditions, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		span.End(err)
+		return 0, errors.Wrap(err, "s.blockBlobURL.Download")
+	}
+
+	body := resp.Body(azblob.RetryReaderOptions{})
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:count])
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	span.SetAttributes(trace.Int64(trace.AttrBytes, int64(n)))
+	span.End(err)
+	if err != nil {
+		return n, errors.Wrap(err, "io.ReadFull")
+	}
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// Size returns the blob size reported by GetProperties
+func (s *AzBlockBlob) Size() int64 {
+	return s.fileSize
+}
+
+// CacheKey returns the account host, path and ETag of the blob s was opened
+// on, as used by the diskcache package. The version is empty when the ETag
+// is unknown. ReadAt only reads that version.
+func (s *AzBlockBlob) CacheKey() (backend, bucket, object, version string) {
+	if s.URL == nil {
+		return "azblob", "", "", ""
+	}
+	return "azblob", s.URL.Host, s.URL.Path, s.ETag
+}
+
 // Write len(p) bytes from p
 func (s *AzBlockBlob) Write(p []byte) (n int, err error) {
 	if s.blockBlobURL == nil {
@@ -174,7 +247,9 @@ func (s *AzBlockBlob) Close() error {
 		}
 
 		// wait for pending uploads
+		_, span := trace.Start(s.ctx, "azblob.Close", s.attributes()...)
 		err = <-s.writeDone
+		span.End(err)
 	}
 
 	return err
@@ -196,7 +271,9 @@ func (s *AzBlockBlob) Open(URL string) (source.ParquetFile, error) {
 	blobURL := azblob.NewBlockBlobURL(*u, azblob.NewPipeline(s.credential, azblob.PipelineOptions{HTTPSender: s.readerOptions.HTTPSender, Retry: s.readerOptions.RetryOptions, Log: s.readerOptions.Log}))
 
 	fileSize := int64(-1)
-	props, err := blobURL.GetProperties(s.ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	ctx, span := trace.Start(s.ctx, "azblob.GetProperties", urlAttributes(*u)...)
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	span.End(err)
 	if err != nil {
 		return &AzBlockBlob{}, errors.Wrap(err, "blobURL.GetProperties")
 	}
@@ -205,6 +282,7 @@ func (s *AzBlockBlob) Open(URL string) (source.ParquetFile, error) {
 	pf := &AzBlockBlob{
 		ctx:           s.ctx,
 		URL:           u,
+		ETag:          string(props.ETag()),
 		credential:    s.credential,
 		blockBlobURL:  &blobURL,
 		fileSize:      fileSize,
@@ -230,7 +308,10 @@ func (s *AzBlockBlob) Create(URL string) (source.ParquetFile, error) {
 	blobURL := azblob.NewBlockBlobURL(*u, azblob.NewPipeline(s.credential, azblob.PipelineOptions{HTTPSender: s.writerOptions.HTTPSender, Retry: s.writerOptions.RetryOptions, Log: s.writerOptions.Log}))
 
 	// get account properties to validate credentials
-	if _, err := blobURL.GetAccountInfo(s.ctx); err != nil {
+	ctx, span := trace.Start(s.ctx, "azblob.GetAccountInfo", urlAttributes(*u)...)
+	_, err := blobURL.GetAccountInfo(ctx)
+	span.End(err)
+	if err != nil {
 		return nil, errors.Wrap(err, "blobURL.GetAccountInfo")
 	}
 
@@ -248,8 +329,11 @@ func (s *AzBlockBlob) Create(URL string) (source.ParquetFile, error) {
 	go func(ctx context.Context, blobURL *azblob.BlockBlobURL, o WriterOptions, reader io.Reader, readerPipeSource *io.PipeWriter, done chan error) {
 		defer close(done)
 
-		// upload data and signal done when complete
+		// upload data and signal done when complete, the blocks are staged
+		// by the azblob pipeline and traced as a single span
+		ctx, span := trace.Start(ctx, "azblob.UploadStreamToBlockBlob", urlAttributes(blobURL.URL())...)
 		_, err := azblob.UploadStreamToBlockBlob(ctx, reader, *blobURL, azblob.UploadStreamToBlockBlobOptions{MaxBuffers: o.Parallelism})
+		span.End(err)
 		if err != nil {
 			err = errors.Wrap(err, "azblob.UploadStreamToBlockBlob")
 			readerPipeSource.CloseWithError(err)
@@ -260,3 +344,26 @@ func (s *AzBlockBlob) Create(URL string) (source.ParquetFile, error) {
 
 	return pf, nil
 }
+
+// attributes returns the span attributes identifying the blob
+func (s *AzBlockBlob) attributes(attrs ...trace.Attribute) []trace.Attribute {
+	if s.URL == nil {
+		return append([]trace.Attribute{trace.String(trace.AttrBackend, "azblob")}, attrs...)
+	}
+	return append(urlAttributes(*s.URL), attrs...)
+}
+
+// urlAttributes returns the span attributes identifying the blob at u
+func urlAttributes(u url.URL) []trace.Attribute {
+	parts := azblob.NewBlobURLParts(u)
+	return []trace.Attribute{
+		trace.String(trace.AttrBackend, "azblob"),
+		trace.String(trace.AttrBucket, parts.ContainerName),
+		trace.String(trace.AttrKey, parts.BlobName),
+	}
+}
+
+// rangeAttribute formats a download request
+func rangeAttribute(offset int64, count int64) string {
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+count-1)
+}
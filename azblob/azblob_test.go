@@ -1,15 +1,132 @@
 package azblob
 
 import (
+	"bytes"
 	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
 )
 
+type fakeBlob struct {
+	data []byte
+	etag string
+}
+
+// fakeServer implements the parts of the Blob service used by AzBlockBlob:
+// account properties, staged block uploads, blob properties and ranged
+// downloads
+type fakeServer struct {
+	*httptest.Server
+
+	lock   sync.Mutex
+	blobs  map[string]fakeBlob
+	blocks map[string][]byte
+	etags  int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{blobs: map[string]fakeBlob{}, blocks: map[string][]byte{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("restype") == "account" && query.Get("comp") == "properties":
+		w.Header().Set("x-ms-sku-name", "Standard_LRS")
+		w.Header().Set("x-ms-account-kind", "StorageV2")
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.blocks[r.URL.Path+"\x00"+query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		s.commit(w, r)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		blob, ok := s.blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", blob.etag)
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(blob.data))
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+// commit stores the blob made of the staged blocks listed in the body
+func (s *fakeServer) commit(w http.ResponseWriter, r *http.Request) {
+	var list struct {
+		Latest []string `xml:"Latest"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	for _, id := range list.Latest {
+		block, ok := s.blocks[r.URL.Path+"\x00"+id]
+		if !ok {
+			http.Error(w, "unknown block "+id, http.StatusBadRequest)
+			return
+		}
+		data = append(data, block...)
+	}
+	s.etags++
+	blob := fakeBlob{data: data, etag: fmt.Sprintf("\"0x%d\"", s.etags)}
+	s.blobs[r.URL.Path] = blob
+	w.Header().Set("ETag", blob.etag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestConformance(t *testing.T) {
+	server := newFakeServer(t)
+	ctx := context.Background()
+	credential := azblob.NewAnonymousCredential()
+	options := WriterOptions{RetryOptions: azblob.RetryOptions{MaxTries: 1}}
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("%s/container/conformance/%d.parquet", server.URL, files)
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewAzBlobFileWriter(ctx, newName(t), credential, options)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
+
 func TestOpen_permissoin(t *testing.T) {
 	testCases := []struct {
 		url string
@@ -53,3 +170,34 @@ func TestOpen_permissoin(t *testing.T) {
 		}
 	}
 }
+
+func TestReadAtETag(t *testing.T) {
+	server := newFakeServer(t)
+	ctx := context.Background()
+	credential := azblob.NewAnonymousCredential()
+	uri := server.URL + "/container/data.parquet"
+
+	write := func(data []byte) {
+		pf, err := NewAzBlobFileWriter(ctx, uri, credential, WriterOptions{})
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		if _, err = pf.Write(data); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		if err = pf.Close(); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	write(sourcetest.Data())
+	r, err := NewAzBlobFileReader(ctx, uri, credential, ReaderOptions{RetryOptions: azblob.RetryOptions{MaxTries: 1}})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	// overwrite the blob, ReadAt keeps the ETag r was opened on
+	write([]byte("overwritten"))
+	if _, err = r.(*AzBlockBlob).ReadAt(make([]byte, 10), 0); err == nil {
+		t.Error("expected ReadAt of an overwritten blob to fail")
+	}
+}
@@ -0,0 +1,276 @@
+package blockcache
+
+import (
+	"container/list"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+const (
+	// DefaultBlockSize is the block size used when Options.BlockSize is unset
+	DefaultBlockSize = 1 << 20
+	// DefaultMaxBlocks is the number of blocks kept when Options.MaxBlocks is unset
+	DefaultMaxBlocks = 16
+)
+
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+)
+
+// Options configure the block cache
+type Options struct {
+	// BlockSize is the size in bytes of the aligned blocks fetched from the source
+	BlockSize int64
+	// MaxBlocks is the number of blocks kept in memory, least recently used
+	// blocks are evicted first
+	MaxBlocks int
+}
+
+// BlockCacheFile serves reads of a remote ParquetFile from fixed-size aligned
+// blocks kept in an LRU. Files returned by Open("") share the cache, so the
+// blocks fetched for one column reader are reused by the others.
+type BlockCacheFile struct {
+	source source.ParquetFile
+	cache  *blockCache
+	size   int64
+	offset int64
+}
+
+// NewBlockCacheFile wraps a ParquetFile opened for reading
+func NewBlockCacheFile(pf source.ParquetFile, options Options) (source.ParquetFile, error) {
+	if options.BlockSize <= 0 {
+		options.BlockSize = DefaultBlockSize
+	}
+	if options.MaxBlocks <= 0 {
+		options.MaxBlocks = DefaultMaxBlocks
+	}
+
+	size, err := sourceutil.Size(pf)
+	if err != nil {
+		return nil, errors.Wrap(err, "sourceutil.Size")
+	}
+
+	return &BlockCacheFile{
+		source: pf,
+		cache:  newBlockCache(options),
+		size:   size,
+	}, nil
+}
+
+// Size returns the size of the underlying file
+func (f *BlockCacheFile) Size() int64 {
+	return f.size
+}
+
+// Seek sets the offset for the next Read
+func (f *BlockCacheFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+
+	if offset < 0 || offset > f.size {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+// Read up to len(p) bytes into p, fetching any missing blocks from the
+// underlying file. io.EOF is returned when p could not be filled.
+func (f *BlockCacheFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	end := f.offset + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+
+	blockSize := f.cache.blockSize
+	first, last := f.offset/blockSize, (end-1)/blockSize
+	blocks, err := f.blocks(first, last)
+	if err != nil {
+		return 0, errors.Wrap(err, "f.blocks")
+	}
+
+	var n int
+	for i, block := range blocks {
+		start := (first + int64(i)) * blockSize
+		from := int64(0)
+		if start < f.offset {
+			from = f.offset - start
+		}
+		n += copy(p[n:], block[from:])
+	}
+	f.offset += int64(n)
+
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// blocks returns the blocks first to last, fetching each contiguous run of
+// missing blocks with a single read
+func (f *BlockCacheFile) blocks(first int64, last int64) ([][]byte, error) {
+	blocks := make([][]byte, last-first+1)
+	for i := range blocks {
+		blocks[i] = f.cache.get(first + int64(i))
+	}
+
+	for i := 0; i < len(blocks); {
+		if blocks[i] != nil {
+			i++
+			continue
+		}
+		j := i
+		for j < len(blocks) && blocks[j] == nil {
+			j++
+		}
+		fetched, err := f.fetch(first+int64(i), first+int64(j)-1)
+		if err != nil {
+			return nil, errors.Wrap(err, "f.fetch")
+		}
+		copy(blocks[i:j], fetched)
+		i = j
+	}
+	return blocks, nil
+}
+
+// fetch reads the blocks first to last from the underlying file and adds
+// them to the cache
+func (f *BlockCacheFile) fetch(first int64, last int64) ([][]byte, error) {
+	blockSize := f.cache.blockSize
+	start := first * blockSize
+	end := (last + 1) * blockSize
+	if end > f.size {
+		end = f.size
+	}
+
+	buf := make([]byte, end-start)
+	n, err := sourceutil.ReadAt(f.source, buf, start)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(buf)) {
+		return nil, errors.Wrap(err, "sourceutil.ReadAt")
+	}
+
+	blocks := make([][]byte, 0, last-first+1)
+	for index := first; index <= last; index++ {
+		from := (index - first) * blockSize
+		to := from + blockSize
+		if to > int64(len(buf)) {
+			to = int64(len(buf))
+		}
+		block := buf[from:to:to]
+		f.cache.add(index, block)
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Write is not supported, use Create on the underlying file to write
+func (f *BlockCacheFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("BlockCacheFile does not support Write()")
+}
+
+// Close closes the underlying file
+func (f *BlockCacheFile) Close() error {
+	if err := f.source.Close(); err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open opens the underlying file again. Opening the same file (an empty
+// name) shares the block cache with f.
+func (f *BlockCacheFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	if name != "" {
+		cached, err := NewBlockCacheFile(pf, f.cache.options)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewBlockCacheFile")
+		}
+		return cached, nil
+	}
+
+	return &BlockCacheFile{
+		source: pf,
+		cache:  f.cache,
+		size:   f.size,
+	}, nil
+}
+
+// Create delegates to the underlying file, writes are not cached
+func (f *BlockCacheFile) Create(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Create(name)
+	if err != nil {
+		return pf, errors.Wrap(err, "f.source.Create")
+	}
+	return pf, nil
+}
+
+// blockCache is an LRU of blocks indexed by their position in the file
+type blockCache struct {
+	options   Options
+	blockSize int64
+
+	lock   sync.Mutex
+	lru    *list.List
+	blocks map[int64]*list.Element
+}
+
+type cacheEntry struct {
+	index int64
+	data  []byte
+}
+
+func newBlockCache(options Options) *blockCache {
+	return &blockCache{
+		options:   options,
+		blockSize: options.BlockSize,
+		lru:       list.New(),
+		blocks:    map[int64]*list.Element{},
+	}
+}
+
+func (c *blockCache) get(index int64) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.blocks[index]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data
+}
+
+func (c *blockCache) add(index int64, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.blocks[index]; ok {
+		elem.Value.(*cacheEntry).data = data
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.blocks[index] = c.lru.PushFront(&cacheEntry{index: index, data: data})
+	for c.lru.Len() > c.options.MaxBlocks {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.blocks, oldest.Value.(*cacheEntry).index)
+	}
+}
@@ -0,0 +1,156 @@
+package blockcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/s3test"
+	"github.com/sabey/parquet-go-source/s3v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/source"
+	"github.com/sabey/parquet-go/writer"
+)
+
+func TestConformance(t *testing.T) {
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			pf, err := NewBlockCacheFile(buffer.NewBufferFileFromBytes(data), Options{BlockSize: 64, MaxBlocks: 4})
+			if err != nil {
+				t.Fatalf("NewBlockCacheFile: %v", err)
+			}
+			return pf
+		},
+	})
+}
+
+func TestReadsAreServedFromBlocks(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	data := sourcetest.Data()
+	server.PutObject("test-bucket", "data", data)
+
+	s3File, err := s3v2.NewS3FileReaderWithClient(context.Background(), server.ClientV2(), "test-bucket", "data")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf, err := NewBlockCacheFile(s3File, Options{BlockSize: 256, MaxBlocks: 2})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.ResetRequests()
+
+	// many small reads inside two blocks
+	b := make([]byte, 8)
+	for i := 0; i < 64; i++ {
+		if _, err = pf.Read(b); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		if !bytes.Equal(b, data[i*8:i*8+8]) {
+			t.Fatalf("expected read %d to match the source data", i)
+		}
+	}
+	if gets := len(server.Gets()); gets != 2 {
+		t.Errorf("expected 2 ranged GETs but got %d", gets)
+	}
+
+	// siblings share the cache
+	sibling, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = sibling.Seek(300, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = sibling.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if gets := len(server.Gets()); gets != 2 {
+		t.Errorf("expected sibling to reuse cached blocks but got %d GETs", gets)
+	}
+
+	// a read spanning several missing blocks is a single request
+	server.ResetRequests()
+	if _, err = pf.Seek(520, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b = make([]byte, 400)
+	if _, err = pf.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data[520:920]) {
+		t.Error("expected multi-block read to match the source data")
+	}
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Range != "bytes=512-999" {
+		t.Errorf("expected a single GET of bytes=512-999 but got %+v", requests)
+	}
+}
+
+func TestParquetRequestCount(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.CreateBucket("test-bucket")
+	client := server.ClientV2()
+	ctx := context.Background()
+
+	fw, err := s3v2.NewS3FileWriterWithClient(ctx, client, "test-bucket", "students.parquet", nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pw, err := writer.NewParquetWriter(fw, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 1000; i++ {
+		if err = pw.Write(sourcetest.Student{Name: "name", Age: int32(i), ID: int64(i)}); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	readAll := func(pf source.ParquetFile) int {
+		server.ResetRequests()
+		pr, err := reader.NewParquetReader(pf, new(sourcetest.Student), 1)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		rows := make([]sourcetest.Student, pr.GetNumRows())
+		if err = pr.Read(&rows); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		pr.ReadStop()
+		if len(rows) != 1000 || rows[999].ID != 999 {
+			t.Fatalf("expected to read back 1000 rows but got %d", len(rows))
+		}
+		return len(server.Gets())
+	}
+
+	direct, err := s3v2.NewS3FileReaderWithClient(ctx, client, "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	uncached := readAll(direct)
+
+	direct, err = s3v2.NewS3FileReaderWithClient(ctx, client, "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf, err := NewBlockCacheFile(direct, Options{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	cached := readAll(pf)
+
+	if cached != 1 {
+		t.Errorf("expected a single GET for a file smaller than one block but got %d (uncached: %d)", cached, uncached)
+	}
+}
@@ -51,6 +51,8 @@ func (bf *BufferFile) Seek(offset int64, whence int) (int64, error) {
 		newLoc += int(offset)
 	case io.SeekEnd:
 		newLoc = len(bf.buff) + int(offset)
+	default:
+		return int64(bf.loc), errors.New("invalid whence")
 	}
 
 	if newLoc < 0 {
@@ -66,12 +68,30 @@ func (bf *BufferFile) Seek(offset int64, whence int) (int64, error) {
 	return int64(bf.loc), nil
 }
 
-// Read reads data form BufferFile into p.
+// Read reads data form BufferFile into p. io.EOF is only returned when p
+// could not be filled.
 func (bf *BufferFile) Read(p []byte) (n int, err error) {
 	n = copy(p, bf.buff[bf.loc:len(bf.buff)])
 	bf.loc += n
 
-	if bf.loc == len(bf.buff) {
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes starting at off without moving the offset used
+// by Read. io.EOF is only returned when p could not be filled.
+func (bf *BufferFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("unable to read at a location <0")
+	}
+	if off < int64(len(bf.buff)) {
+		n = copy(p, bf.buff[off:])
+	}
+
+	if n < len(p) {
 		return n, errors.Wrap(io.EOF, "io.EOF")
 	}
 
@@ -0,0 +1,22 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+func TestConformance(t *testing.T) {
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			return NewBufferFileFromBytes(data)
+		},
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			return NewBufferFile()
+		},
+		NewName: func(t *testing.T) string {
+			return ""
+		},
+	})
+}
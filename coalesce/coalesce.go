@@ -0,0 +1,314 @@
+package coalesce
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+const (
+	// DefaultMaxGap is the gap used when Options.MaxGap is unset
+	DefaultMaxGap = 1 << 20
+	// DefaultMaxRequestSize is the request size used when Options.MaxRequestSize is unset
+	DefaultMaxRequestSize = 32 << 20
+	// DefaultMaxBufferedBytes is the buffer size used when Options.MaxBufferedBytes is unset
+	DefaultMaxBufferedBytes = 64 << 20
+)
+
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+)
+
+// Options configure the read planner
+type Options struct {
+	// MaxGap is the largest number of unrequested bytes between two ranges
+	// that are still merged into one request
+	MaxGap int64
+	// MaxRequestSize caps the size of a merged request, a single Read larger
+	// than MaxRequestSize is never split
+	MaxRequestSize int64
+	// MaxBufferedBytes caps the fetched bytes kept for the handles that have
+	// not read them yet, the oldest ranges are dropped first
+	MaxBufferedBytes int64
+}
+
+// CoalesceFile merges the reads of the handles returned by Open("") into
+// fewer requests. parquet-go opens one handle per column chunk and seeks
+// each of them before reading, the planner uses those seek offsets to fetch
+// the chunks that lie within MaxGap of each other with a single read.
+type CoalesceFile struct {
+	source  source.ParquetFile
+	planner *planner
+	size    int64
+	offset  int64
+}
+
+// NewCoalesceFile wraps a ParquetFile opened for reading
+func NewCoalesceFile(pf source.ParquetFile, options Options) (source.ParquetFile, error) {
+	if options.MaxGap <= 0 {
+		options.MaxGap = DefaultMaxGap
+	}
+	if options.MaxRequestSize <= 0 {
+		options.MaxRequestSize = DefaultMaxRequestSize
+	}
+	if options.MaxBufferedBytes <= 0 {
+		options.MaxBufferedBytes = DefaultMaxBufferedBytes
+	}
+
+	size, err := sourceutil.Size(pf)
+	if err != nil {
+		return nil, errors.Wrap(err, "sourceutil.Size")
+	}
+
+	return &CoalesceFile{
+		source:  pf,
+		planner: newPlanner(options, size),
+		size:    size,
+	}, nil
+}
+
+// Size returns the size of the underlying file
+func (f *CoalesceFile) Size() int64 {
+	return f.size
+}
+
+// Seek sets the offset for the next Read and records it as a hint for the
+// other handles
+func (f *CoalesceFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+
+	if offset < 0 || offset > f.size {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	f.offset = offset
+	f.planner.hint(f, offset)
+	return f.offset, nil
+}
+
+// Read up to len(p) bytes into p. Bytes already fetched for this handle are
+// served from memory, a miss fetches the planned range. io.EOF is returned
+// when p could not be filled.
+func (f *CoalesceFile) Read(p []byte) (int, error) {
+	f.planner.done(f)
+	if f.offset >= f.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	end := f.offset + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+
+	var n int
+	for f.offset < end {
+		if c := f.planner.copy(p[n:end-f.offset+int64(n)], f.offset); c > 0 {
+			n += c
+			f.offset += int64(c)
+			continue
+		}
+		if err := f.fetch(end - f.offset); err != nil {
+			return n, errors.Wrap(err, "f.fetch")
+		}
+	}
+
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// fetch reads the range planned around the next length bytes of f
+func (f *CoalesceFile) fetch(length int64) error {
+	start, end := f.planner.plan(f.offset, length)
+
+	buf := make([]byte, end-start)
+	n, err := sourceutil.ReadAt(f.source, buf, start)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(buf)) {
+		return errors.Wrap(err, "sourceutil.ReadAt")
+	}
+	f.planner.add(start, buf)
+	return nil
+}
+
+// Write is not supported, use Create on the underlying file to write
+func (f *CoalesceFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("CoalesceFile does not support Write()")
+}
+
+// Close closes the underlying file and drops its hint
+func (f *CoalesceFile) Close() error {
+	f.planner.done(f)
+	if err := f.source.Close(); err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open opens the underlying file again. Opening the same file (an empty
+// name) shares the planner with f.
+func (f *CoalesceFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	if name != "" {
+		coalesced, err := NewCoalesceFile(pf, f.planner.options)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewCoalesceFile")
+		}
+		return coalesced, nil
+	}
+
+	return &CoalesceFile{
+		source:  pf,
+		planner: f.planner,
+		size:    f.size,
+	}, nil
+}
+
+// Create delegates to the underlying file
+func (f *CoalesceFile) Create(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Create(name)
+	if err != nil {
+		return pf, errors.Wrap(err, "f.source.Create")
+	}
+	return pf, nil
+}
+
+// planner tracks the pending offsets of the handles sharing a file and the
+// ranges fetched on their behalf
+type planner struct {
+	options Options
+	size    int64
+
+	lock     sync.Mutex
+	hints    map[*CoalesceFile]int64
+	segments []segment
+	buffered int64
+}
+
+// segment is a fetched range of the file
+type segment struct {
+	offset int64
+	data   []byte
+}
+
+func newPlanner(options Options, size int64) *planner {
+	return &planner{
+		options: options,
+		size:    size,
+		hints:   map[*CoalesceFile]int64{},
+	}
+}
+
+// hint records that f will read at offset
+func (p *planner) hint(f *CoalesceFile, offset int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hints[f] = offset
+}
+
+// done drops the hint of f once it starts reading
+func (p *planner) done(f *CoalesceFile) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.hints, f)
+}
+
+// plan returns the range to fetch for a read of length bytes at offset. The
+// pending hints are assumed to read as many bytes, and each of them within
+// MaxGap of the range is merged as long as the range stays under
+// MaxRequestSize.
+func (p *planner) plan(offset int64, length int64) (int64, int64) {
+	p.lock.Lock()
+	hints := make([]int64, 0, len(p.hints))
+	for _, h := range p.hints {
+		hints = append(hints, h)
+	}
+	p.lock.Unlock()
+	sort.Slice(hints, func(i, j int) bool { return hints[i] < hints[j] })
+
+	start, end := offset, offset+length
+	fits := func(s int64, e int64) bool {
+		return e-s <= p.options.MaxRequestSize
+	}
+
+	// hints after the read, in increasing order
+	for _, h := range hints {
+		if h < offset {
+			continue
+		}
+		if h > end+p.options.MaxGap {
+			break
+		}
+		e := h + length
+		if e > p.size {
+			e = p.size
+		}
+		if e <= end {
+			continue
+		}
+		if !fits(start, e) {
+			break
+		}
+		end = e
+	}
+
+	// hints before the read, in decreasing order
+	for i := len(hints) - 1; i >= 0; i-- {
+		h := hints[i]
+		if h >= offset {
+			continue
+		}
+		if h+length < start-p.options.MaxGap {
+			break
+		}
+		if !fits(h, end) {
+			break
+		}
+		start = h
+	}
+
+	if end > p.size {
+		end = p.size
+	}
+	return start, end
+}
+
+// copy fills b with the fetched bytes at offset and returns how many were
+// available
+func (p *planner) copy(b []byte, offset int64) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, s := range p.segments {
+		if offset >= s.offset && offset < s.offset+int64(len(s.data)) {
+			return copy(b, s.data[offset-s.offset:])
+		}
+	}
+	return 0
+}
+
+// add keeps a fetched range, dropping the oldest ones above MaxBufferedBytes
+func (p *planner) add(offset int64, data []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.segments = append(p.segments, segment{offset: offset, data: data})
+	p.buffered += int64(len(data))
+	for len(p.segments) > 1 && p.buffered > p.options.MaxBufferedBytes {
+		p.buffered -= int64(len(p.segments[0].data))
+		p.segments = p.segments[1:]
+	}
+}
@@ -0,0 +1,164 @@
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/s3test"
+	"github.com/sabey/parquet-go-source/s3v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/source"
+	"github.com/sabey/parquet-go/writer"
+)
+
+func TestConformance(t *testing.T) {
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			pf, err := NewCoalesceFile(buffer.NewBufferFileFromBytes(data), Options{MaxGap: 16, MaxRequestSize: 128, MaxBufferedBytes: 256})
+			if err != nil {
+				t.Fatalf("NewCoalesceFile: %v", err)
+			}
+			return pf
+		},
+	})
+}
+
+func ranges(server *s3test.Server) []string {
+	var ranges []string
+	for _, r := range server.Gets() {
+		ranges = append(ranges, r.Range)
+	}
+	return ranges
+}
+
+func TestNearbyReadsAreMerged(t *testing.T) {
+	testcases := []struct {
+		name     string
+		options  Options
+		expected []string
+	}{
+		{"merged", Options{MaxGap: 64}, []string{"bytes=0-349"}},
+		{"gap too large", Options{MaxGap: 10}, []string{"bytes=0-199", "bytes=250-349"}},
+		{"request too large", Options{MaxGap: 64, MaxRequestSize: 250}, []string{"bytes=0-199", "bytes=250-349"}},
+	}
+
+	server := s3test.NewServer()
+	defer server.Close()
+	data := sourcetest.Data()
+	server.PutObject("test-bucket", "data", data)
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s3File, err := s3v2.NewS3FileReaderWithClient(context.Background(), server.ClientV2(), "test-bucket", "data")
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			pf, err := NewCoalesceFile(s3File, tc.options)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			server.ResetRequests()
+
+			// one handle per column chunk, seeked before any of them reads
+			offsets := []int64{0, 100, 250}
+			handles := make([]source.ParquetFile, len(offsets))
+			for i, offset := range offsets {
+				if handles[i], err = pf.Open(""); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if _, err = handles[i].Seek(offset, io.SeekStart); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+			}
+			for i, offset := range offsets {
+				b := make([]byte, 100)
+				if _, err = handles[i].Read(b); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if !bytes.Equal(b, data[offset:offset+100]) {
+					t.Errorf("expected read at %d to match the source data", offset)
+				}
+			}
+
+			got := ranges(server)
+			if len(got) != len(tc.expected) {
+				t.Fatalf("expected GETs %v but got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Errorf("expected GETs %v but got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParquetRequestCount(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.CreateBucket("test-bucket")
+	client := server.ClientV2()
+	ctx := context.Background()
+
+	fw, err := s3v2.NewS3FileWriterWithClient(ctx, client, "test-bucket", "students.parquet", nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pw, err := writer.NewParquetWriter(fw, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 1000; i++ {
+		if err = pw.Write(sourcetest.Student{Name: "name", Age: int32(i), ID: int64(i)}); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	readAll := func(pf source.ParquetFile) int {
+		server.ResetRequests()
+		pr, err := reader.NewParquetReader(pf, new(sourcetest.Student), 1)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		rows := make([]sourcetest.Student, pr.GetNumRows())
+		if err = pr.Read(&rows); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		pr.ReadStop()
+		if len(rows) != 1000 || rows[999].ID != 999 {
+			t.Fatalf("expected to read back 1000 rows but got %d", len(rows))
+		}
+		return len(ranges(server))
+	}
+
+	direct, err := s3v2.NewS3FileReaderWithClient(ctx, client, "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	uncached := readAll(direct)
+
+	direct, err = s3v2.NewS3FileReaderWithClient(ctx, client, "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf, err := NewCoalesceFile(direct, Options{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	coalesced := readAll(pf)
+
+	// footer length, footer, then every column chunk at once
+	if coalesced != 3 {
+		t.Errorf("expected 3 GETs but got %d (uncoalesced: %d)", coalesced, uncached)
+	}
+}
@@ -0,0 +1,521 @@
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+const (
+	// DefaultBlockSize is the block size used when Options.BlockSize is unset
+	DefaultBlockSize = 1 << 20
+	// DefaultMaxBytes is the cache size used when Options.MaxBytes is unset
+	DefaultMaxBytes = 1 << 30
+
+	// tmpPrefix starts the names of blocks being written
+	tmpPrefix = ".tmp-"
+	// staleTmpAge is the age after which a block being written is assumed
+	// to be left over by a process that died
+	staleTmpAge = time.Hour
+)
+
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+	// ErrNoVersion is returned by KeyFor when the object version is unknown,
+	// caching it could serve stale data after an overwrite
+	ErrNoVersion = errors.New("diskcache: object version is unknown")
+	// ErrUnsupported is returned by KeyFor for sources that are not Keyed
+	ErrUnsupported = errors.New("diskcache: unsupported source")
+)
+
+// Options configure a Cache
+type Options struct {
+	// BlockSize is the size in bytes of the aligned ranges stored on disk
+	BlockSize int64
+	// MaxBytes caps the size of the cache directory, least recently used
+	// blocks are removed first
+	MaxBytes int64
+}
+
+// Key identifies one version of a remote object
+type Key struct {
+	Backend string
+	Bucket  string
+	Object  string
+	// Version is the ETag or generation of the object
+	Version string
+}
+
+// Cache stores byte ranges of remote objects below a local directory. The
+// directory can be shared by several processes. Each Cache keeps the size
+// and last use of the blocks it knows about in memory: the blocks found when
+// it is created and those it reads or stores afterwards.
+type Cache struct {
+	dir     string
+	options Options
+
+	lock    sync.Mutex
+	used    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// New creates a Cache storing its data in dir, which is created if needed.
+// Blocks left over by processes that died while writing them are removed.
+func New(dir string, options Options) (*Cache, error) {
+	if options.BlockSize <= 0 {
+		options.BlockSize = DefaultBlockSize
+	}
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "os.MkdirAll")
+	}
+
+	c := &Cache{
+		dir:     dir,
+		options: options,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+	blocks, stale, err := c.blocks()
+	if err != nil {
+		return nil, errors.Wrap(err, "c.blocks")
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].modified.Before(blocks[j].modified)
+	})
+	for _, b := range blocks {
+		c.touch(b.path, b.size)
+	}
+	if c.used > c.options.MaxBytes {
+		if err = c.evict(); err != nil {
+			return nil, errors.Wrap(err, "c.evict")
+		}
+	}
+	return c, nil
+}
+
+// Keyed is implemented by readers that can name the object version they
+// read, such as those of the s3, s3v2, gcs and azblob packages. Blocks are
+// only fetched with ReadAt, which must fail once that version is
+// overwritten so that blocks of another version are never stored under its
+// key.
+type Keyed interface {
+	io.ReaderAt
+	// CacheKey returns the backend, bucket and object of the reader, and the
+	// version it was opened on or an empty string when it is unknown
+	CacheKey() (backend, bucket, object, version string)
+}
+
+// KeyFor returns the cache key of a Keyed reader
+func KeyFor(pf source.ParquetFile) (Key, error) {
+	keyed, ok := pf.(Keyed)
+	if !ok {
+		return Key{}, errors.Wrapf(ErrUnsupported, "%T", pf)
+	}
+	var key Key
+	key.Backend, key.Bucket, key.Object, key.Version = keyed.CacheKey()
+	if key.Version == "" {
+		return key, errors.Wrap(ErrNoVersion, key.Object)
+	}
+	return key, nil
+}
+
+// Wrap returns a ParquetFile reading pf through the cache, the key is
+// derived with KeyFor
+func (c *Cache) Wrap(pf source.ParquetFile) (source.ParquetFile, error) {
+	key, err := KeyFor(pf)
+	if err != nil {
+		return nil, errors.Wrap(err, "KeyFor")
+	}
+	cached, err := c.NewDiskCacheFile(pf, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "c.NewDiskCacheFile")
+	}
+	return cached, nil
+}
+
+// NewDiskCacheFile returns a ParquetFile reading pf through the cache. key
+// must change whenever the content of the object changes, and the reads of
+// pf must fail rather than return the content of another key.
+func (c *Cache) NewDiskCacheFile(pf source.ParquetFile, key Key) (source.ParquetFile, error) {
+	size, err := sourceutil.Size(pf)
+	if err != nil {
+		return nil, errors.Wrap(err, "sourceutil.Size")
+	}
+
+	return &DiskCacheFile{
+		source:  pf,
+		cache:   c,
+		dir:     c.objectDir(key),
+		size:    size,
+		touched: map[int64]bool{},
+	}, nil
+}
+
+func (c *Cache) objectDir(key Key) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%d", key.Backend, key.Bucket, key.Object, key.Version, c.options.BlockSize)
+	return filepath.Join(c.dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// get reads len(p) bytes at off of the block stored at path into p, and
+// reports whether the block exists with the expected size. The access time
+// of the block, which orders eviction across processes, is updated when
+// touch is set.
+func (c *Cache) get(path string, size int64, p []byte, off int64, touch bool) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() != size {
+		return false
+	}
+	if _, err = file.ReadAt(p, off); err != nil {
+		return false
+	}
+
+	if touch {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	c.lock.Lock()
+	c.touch(path, size)
+	c.lock.Unlock()
+	return true
+}
+
+// put stores a block, the file is renamed into place so that concurrent
+// readers never observe a partial block
+func (c *Cache) put(dir string, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "os.MkdirAll")
+	}
+	tmp, err := ioutil.TempFile(dir, tmpPrefix)
+	if err != nil {
+		return errors.Wrap(err, "ioutil.TempFile")
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "tmp.Write")
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "tmp.Close")
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "os.Rename")
+	}
+
+	c.lock.Lock()
+	c.touch(path, int64(len(data)))
+	over := c.used > c.options.MaxBytes
+	c.lock.Unlock()
+	if over {
+		if err = c.evict(); err != nil {
+			return errors.Wrap(err, "c.evict")
+		}
+	}
+	return nil
+}
+
+// touch marks the block at path as the most recently used one, a block
+// fetched again replaces the previous one. c.lock must be held.
+func (c *Cache) touch(path string, size int64) {
+	if elem, ok := c.entries[path]; ok {
+		entry := elem.Value.(*cacheEntry)
+		c.used += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, size: size})
+	c.used += size
+}
+
+type blockInfo struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// blocks lists every block in the cache directory. Blocks being written,
+// possibly by another process, are skipped; those older than staleTmpAge
+// are returned as stale.
+func (c *Cache) blocks() ([]blockInfo, []string, error) {
+	var blocks []blockInfo
+	var stale []string
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed by another process
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), tmpPrefix) {
+			if time.Since(info.ModTime()) > staleTmpAge {
+				stale = append(stale, path)
+			}
+			return nil
+		}
+		blocks = append(blocks, blockInfo{path: path, size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "filepath.Walk")
+	}
+	return blocks, stale, nil
+}
+
+// evict removes the least recently used blocks until the blocks known to c
+// fit in MaxBytes
+func (c *Cache) evict() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.used > c.options.MaxBytes && c.lru.Len() > 0 {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "os.Remove")
+		}
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.path)
+		c.used -= entry.size
+		// drop the object directory once its last block is gone
+		os.Remove(filepath.Dir(entry.path))
+	}
+	return nil
+}
+
+// DiskCacheFile reads a remote ParquetFile through a Cache
+type DiskCacheFile struct {
+	source source.ParquetFile
+	cache  *Cache
+	dir    string
+	size   int64
+	offset int64
+	// touched holds the blocks whose access time was updated by f
+	touched map[int64]bool
+}
+
+// Size returns the size of the underlying file
+func (f *DiskCacheFile) Size() int64 {
+	return f.size
+}
+
+// Seek sets the offset for the next Read
+func (f *DiskCacheFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+
+	if offset < 0 || offset > f.size {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+// Read up to len(p) bytes into p. Only the requested range of the cached
+// blocks is read from disk. Blocks missing from the cache are fetched from
+// the underlying file, each contiguous run with a single read, and written
+// to disk. io.EOF is returned when p could not be filled.
+func (f *DiskCacheFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	end := f.offset + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+	blockSize := f.cache.options.BlockSize
+	first, last := f.offset/blockSize, (end-1)/blockSize
+
+	// dst[i] is the part of p filled from block first+i, starting at
+	// offset from[i] of the block
+	dst := make([][]byte, last-first+1)
+	from := make([]int64, len(dst))
+	missing := make([]bool, len(dst))
+	for i := range dst {
+		index := first + int64(i)
+		start, stop := index*blockSize, (index+1)*blockSize
+		if start < f.offset {
+			start = f.offset
+		}
+		if stop > end {
+			stop = end
+		}
+		dst[i] = p[start-f.offset : stop-f.offset]
+		from[i] = start - index*blockSize
+		missing[i] = !f.cache.get(f.blockPath(index), f.blockLen(index), dst[i], from[i], !f.touched[index])
+		if !missing[i] {
+			f.touched[index] = true
+		}
+	}
+	for i := 0; i < len(dst); {
+		if !missing[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(dst) && missing[j] {
+			j++
+		}
+		blocks, err := f.fetch(first+int64(i), j-i)
+		if err != nil {
+			return 0, errors.Wrap(err, "f.fetch")
+		}
+		for k, block := range blocks {
+			copy(dst[i+k], block[from[i+k]:])
+			f.touched[first+int64(i+k)] = true
+		}
+		i = j
+	}
+
+	n := int(end - f.offset)
+	f.offset = end
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// fetch reads count blocks, starting at block index first, from the
+// underlying file and stores them in the cache. The cache is best effort:
+// blocks that cannot be stored are still returned.
+func (f *DiskCacheFile) fetch(first int64, count int) ([][]byte, error) {
+	blockSize := f.cache.options.BlockSize
+	start := first * blockSize
+	end := start + int64(count)*blockSize
+	if end > f.size {
+		end = f.size
+	}
+
+	buf := make([]byte, end-start)
+	n, err := sourceutil.ReadAt(f.source, buf, start)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(buf)) {
+		return nil, errors.Wrap(err, "sourceutil.ReadAt")
+	}
+
+	blocks := make([][]byte, count)
+	for i := range blocks {
+		from := int64(i) * blockSize
+		to := from + blockSize
+		if to > int64(len(buf)) {
+			to = int64(len(buf))
+		}
+		blocks[i] = buf[from:to:to]
+		// a full disk or a failed eviction only costs a later fetch
+		_ = f.cache.put(f.dir, f.blockPath(first+int64(i)), blocks[i])
+	}
+	return blocks, nil
+}
+
+func (f *DiskCacheFile) blockPath(index int64) string {
+	return filepath.Join(f.dir, strconv.FormatInt(index, 10))
+}
+
+// blockLen returns the expected size of a block, only the last one is short
+func (f *DiskCacheFile) blockLen(index int64) int64 {
+	blockSize := f.cache.options.BlockSize
+	if remaining := f.size - index*blockSize; remaining < blockSize {
+		return remaining
+	}
+	return blockSize
+}
+
+// Write is not supported, use Create on the underlying file to write
+func (f *DiskCacheFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("DiskCacheFile does not support Write()")
+}
+
+// Close closes the underlying file, cached blocks are kept
+func (f *DiskCacheFile) Close() error {
+	if err := f.source.Close(); err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open opens the underlying file again. Files supported by KeyFor are keyed
+// by the version they read, which may differ from the version of f when the
+// underlying file looks the object up again; other files share the blocks
+// of f.
+func (f *DiskCacheFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	if name != "" {
+		cached, err := f.cache.Wrap(pf)
+		if err != nil {
+			return nil, errors.Wrap(err, "f.cache.Wrap")
+		}
+		return cached, nil
+	}
+	if key, err := KeyFor(pf); err == nil {
+		cached, err := f.cache.NewDiskCacheFile(pf, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "f.cache.NewDiskCacheFile")
+		}
+		return cached, nil
+	}
+
+	return &DiskCacheFile{
+		source:  pf,
+		cache:   f.cache,
+		dir:     f.dir,
+		size:    f.size,
+		touched: map[int64]bool{},
+	}, nil
+}
+
+// Create delegates to the underlying file
+func (f *DiskCacheFile) Create(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Create(name)
+	if err != nil {
+		return pf, errors.Wrap(err, "f.source.Create")
+	}
+	return pf, nil
+}
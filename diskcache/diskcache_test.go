@@ -0,0 +1,348 @@
+package diskcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/s3"
+	"github.com/sabey/parquet-go-source/s3test"
+	"github.com/sabey/parquet-go-source/s3v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestConformance(t *testing.T) {
+	cache, err := New(tempDir(t), Options{BlockSize: 64, MaxBytes: 512})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var version int
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			version++
+			key := Key{Backend: "buffer", Object: t.Name(), Version: string(rune('a' + version))}
+			pf, err := cache.NewDiskCacheFile(buffer.NewBufferFileFromBytes(data), key)
+			if err != nil {
+				t.Fatalf("NewDiskCacheFile: %v", err)
+			}
+			return pf
+		},
+	})
+}
+
+func readAll(t *testing.T, cache *Cache, client *s3test.Server, key string) []byte {
+	s3File, err := s3v2.NewS3FileReaderWithClient(context.Background(), client.ClientV2(), "test-bucket", key)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf, err := cache.Wrap(s3File)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	defer pf.Close()
+
+	size, err := pf.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = pf.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, size)
+	if _, err = pf.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return b
+}
+
+func TestCacheIsSharedAcrossInstances(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	data := sourcetest.Data()
+	server.PutObject("test-bucket", "data", data)
+	dir := tempDir(t)
+
+	first, err := New(dir, Options{BlockSize: 256})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.ResetRequests()
+	if b := readAll(t, first, server, "data"); !bytes.Equal(b, data) {
+		t.Error("expected cached read to match the source data")
+	}
+	if gets := len(server.Gets()); gets != 1 {
+		t.Errorf("expected a single GET to fill the cache but got %d", gets)
+	}
+
+	// a second cache on the same directory, as another process would use
+	second, err := New(dir, Options{BlockSize: 256})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.ResetRequests()
+	if b := readAll(t, second, server, "data"); !bytes.Equal(b, data) {
+		t.Error("expected cached read to match the source data")
+	}
+	if gets := len(server.Gets()); gets != 0 {
+		t.Errorf("expected reads to be served from disk but got %d GETs", gets)
+	}
+
+	// overwriting the object changes its ETag
+	changed := bytes.ToUpper(data)
+	server.PutObject("test-bucket", "data", changed)
+	server.ResetRequests()
+	if b := readAll(t, second, server, "data"); !bytes.Equal(b, changed) {
+		t.Error("expected the new version of the object to be read")
+	}
+	if gets := len(server.Gets()); gets != 1 {
+		t.Errorf("expected the new version to be fetched with 1 GET but got %d", gets)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.PutObject("test-bucket", "a", sourcetest.Data())
+	server.PutObject("test-bucket", "b", bytes.ToUpper(sourcetest.Data()))
+	dir := tempDir(t)
+
+	cache, err := New(dir, Options{BlockSize: 100, MaxBytes: 3000})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	readAll(t, cache, server, "a")
+	// age the blocks of a, a cache created afterwards evicts them first
+	old := time.Now().Add(-time.Hour)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			os.Chtimes(path, old, old)
+		}
+		return nil
+	})
+	cache, err = New(dir, Options{BlockSize: 100, MaxBytes: 3000})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	readAll(t, cache, server, "b")
+
+	var used int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			used += info.Size()
+		}
+		return nil
+	})
+	if used > 3000 {
+		t.Errorf("expected at most 3000 bytes on disk but got %d", used)
+	}
+
+	// the most recently read object is still cached
+	server.ResetRequests()
+	readAll(t, cache, server, "b")
+	if gets := len(server.Gets()); gets != 0 {
+		t.Errorf("expected b to be served from disk but got %d GETs", gets)
+	}
+}
+
+func TestOverwriteNotCached(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.PutObject("test-bucket", "data", sourcetest.Data())
+	dir := tempDir(t)
+	cache, err := New(dir, Options{BlockSize: 100})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	// an unversioned s3 reader, whose key is the ETag it was opened on
+	s3File, err := s3.NewS3FileReaderWithClient(context.Background(), server.ClientV1(), "test-bucket", "data")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	key, err := KeyFor(s3File)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf, err := cache.Wrap(s3File)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = pf.Read(make([]byte, 100)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	server.PutObject("test-bucket", "data", bytes.ToUpper(sourcetest.Data()))
+	if _, err = pf.Read(make([]byte, 100)); err == nil {
+		t.Errorf("expected reading a block of the overwritten version to fail")
+	}
+	if _, err = os.Stat(filepath.Join(cache.objectDir(key), "1")); !os.IsNotExist(err) {
+		t.Errorf("expected no block of the new version to be stored but got %v", err)
+	}
+}
+
+func TestKeyForUnsupported(t *testing.T) {
+	_, err := KeyFor(buffer.NewBufferFile())
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected error to be %v but got %v", ErrUnsupported, err)
+	}
+}
+
+// unversioned is a reader that cannot name the version it reads
+type unversioned struct {
+	*buffer.BufferFile
+}
+
+func (unversioned) ReadAt(p []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
+
+func (unversioned) CacheKey() (backend, bucket, object, version string) {
+	return "buffer", "", "data", ""
+}
+
+func TestKeyForNoVersion(t *testing.T) {
+	_, err := KeyFor(unversioned{buffer.NewBufferFile()})
+	if !errors.Is(err, ErrNoVersion) {
+		t.Errorf("expected error to be %v but got %v", ErrNoVersion, err)
+	}
+}
+
+func TestFetchWithoutDisk(t *testing.T) {
+	cache, err := New(tempDir(t), Options{BlockSize: 64})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	key := Key{Backend: "buffer", Object: "data", Version: "a"}
+	// a file in place of the object directory makes every put fail
+	if err = ioutil.WriteFile(cache.objectDir(key), nil, 0644); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	data := sourcetest.Data()
+	pf, err := cache.NewDiskCacheFile(buffer.NewBufferFileFromBytes(data), key)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, 100)
+	if _, err = pf.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data[:100]) {
+		t.Errorf("expected the data read to match")
+	}
+}
+
+func TestPutAccounting(t *testing.T) {
+	dir := tempDir(t)
+	cache, err := New(dir, Options{BlockSize: 64})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	block := filepath.Join(dir, "object", "0")
+	for i := 0; i < 3; i++ {
+		if err = cache.put(filepath.Dir(block), block, make([]byte, 64)); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if cache.used != 64 {
+		t.Errorf("expected a replaced block to be counted once but got %d bytes", cache.used)
+	}
+}
+
+func TestEvictionSkipsBlocksBeingWritten(t *testing.T) {
+	dir := tempDir(t)
+	objectDir := filepath.Join(dir, "object")
+	if err := os.MkdirAll(objectDir, 0755); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	writing := filepath.Join(objectDir, tmpPrefix+"writing")
+	stale := filepath.Join(objectDir, tmpPrefix+"stale")
+	for _, path := range []string{writing, stale} {
+		if err := ioutil.WriteFile(path, make([]byte, 64), 0644); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	old := time.Now().Add(-2 * staleTmpAge)
+	os.Chtimes(stale, old, old)
+
+	cache, err := New(dir, Options{BlockSize: 64, MaxBytes: 100})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		block := filepath.Join(objectDir, strconv.Itoa(i))
+		if err = cache.put(objectDir, block, make([]byte, 64)); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if _, err = os.Stat(writing); err != nil {
+		t.Errorf("expected a block being written to be kept but got %v", err)
+	}
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected a stale block to be removed but got %v", err)
+	}
+	if cache.used != 64 {
+		t.Errorf("expected one block to be kept but got %d bytes", cache.used)
+	}
+}
+
+func TestAccessTimeUpdatedOncePerHandle(t *testing.T) {
+	dir := tempDir(t)
+	cache, err := New(dir, Options{BlockSize: 64})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	key := Key{Backend: "buffer", Object: "data", Version: "a"}
+	data := sourcetest.Data()
+	pf, err := cache.NewDiskCacheFile(buffer.NewBufferFileFromBytes(data), key)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, 8)
+	if _, err = pf.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	block := filepath.Join(cache.objectDir(key), "0")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(block, old, old)
+	if _, err = pf.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if info, err := os.Stat(block); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("expected a block to be touched once per handle but got %v, %v", info.ModTime(), err)
+	}
+
+	other, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = other.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if info, err := os.Stat(block); err != nil || info.ModTime().Equal(old) {
+		t.Errorf("expected a new handle to touch the block but got %v, %v", info.ModTime(), err)
+	}
+	if !bytes.Equal(b, data[:8]) {
+		t.Errorf("expected the data read to match")
+	}
+}
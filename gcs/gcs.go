@@ -2,10 +2,13 @@ package gcs
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strconv"
 
 	"cloud.google.com/go/storage"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
@@ -23,6 +26,7 @@ type GcsFile struct {
 	externalClient bool
 	Bucket         *storage.BucketHandle
 	FilePath       string
+	Generation     int64
 	FileReader     *storage.Reader
 	FileWriter     *storage.Writer
 
@@ -102,6 +106,9 @@ func (self *GcsFile) Create(name string) (source.ParquetFile, error) {
 		gcs.externalClient = self.externalClient
 	}
 	gcs.FilePath = name
+	gcs.Ctx = self.Ctx
+	gcs.ProjectId = self.ProjectId
+	gcs.BucketName = self.BucketName
 	if err != nil {
 		return gcs, errors.Wrap(err, "storage.NewClient")
 	}
@@ -133,17 +140,23 @@ func (self *GcsFile) Open(name string) (source.ParquetFile, error) {
 	// must use existing bucket
 	gcs.Bucket = gcs.Client.Bucket(self.BucketName)
 	obj := gcs.Bucket.Object(gcs.FilePath)
-	attrs, err := obj.Attrs(self.Ctx)
+	ctx, span := trace.Start(self.Ctx, "gcs.Attrs", objectAttributes(self.BucketName, gcs.FilePath)...)
+	attrs, err := obj.Attrs(ctx)
+	span.End(err)
 	if err != nil {
 		return gcs, errors.Wrap(err, "obj.Attrs")
 	}
 	gcs.fileSize = attrs.Size
+	gcs.Generation = attrs.Generation
 	gcs.Ctx = self.Ctx
 	gcs.ProjectId = self.ProjectId
 	gcs.BucketName = self.BucketName
 	return gcs, nil
 }
 
+// Seek tracks the offset for the next Read and returns it. io.SeekEnd
+// offsets are made absolute once the size is known, for objects of unknown
+// size they stay relative to the end and are returned as given.
 func (self *GcsFile) Seek(offset int64, whence int) (int64, error) {
 	if whence < io.SeekStart || whence > io.SeekEnd {
 		return 0, errors.Wrap(errWhence, "errWhence")
@@ -161,9 +174,11 @@ func (self *GcsFile) Seek(offset int64, whence int) (int64, error) {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
 		case io.SeekEnd:
-			if offset > -1 || -offset > self.fileSize {
+			if offset > 0 || -offset > self.fileSize {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
+			offset += self.fileSize
+			whence = io.SeekStart
 		}
 	}
 
@@ -180,8 +195,21 @@ func (self *GcsFile) Read(b []byte) (cnt int, err error) {
 	ln := len(b)
 
 	obj := self.Bucket.Object(self.FilePath)
+	ctx, span := trace.Start(self.Ctx, "gcs.Read", objectAttributes(self.BucketName, self.FilePath)...)
+	defer func() {
+		span.SetAttributes(trace.Int64(trace.AttrBytes, int64(cnt)))
+		if errors.Is(err, io.EOF) {
+			span.End(nil)
+		} else {
+			span.End(err)
+		}
+	}()
 	if self.offset == 0 {
-		self.FileReader, err = obj.NewReader(self.Ctx)
+		span.SetAttributes(trace.String(trace.AttrRange, "bytes=0-"))
+		self.FileReader, err = obj.NewReader(ctx)
+		if err != nil {
+			return cnt, errors.Wrap(err, "obj.NewReader")
+		}
 	} else {
 		var length int64
 		if self.offset < 0 || (self.whence == io.SeekEnd && int64(ln) >= self.fileSize-self.offset) {
@@ -189,7 +217,8 @@ func (self *GcsFile) Read(b []byte) (cnt int, err error) {
 		} else {
 			length = int64(ln)
 		}
-		self.FileReader, err = obj.NewRangeReader(self.Ctx, self.offset, length)
+		span.SetAttributes(trace.String(trace.AttrRange, rangeAttribute(self.offset, length)))
+		self.FileReader, err = obj.NewRangeReader(ctx, self.offset, length)
 		if err != nil {
 			return cnt, errors.Wrap(err, "obj.NewRangeReader")
 		}
@@ -205,12 +234,71 @@ func (self *GcsFile) Read(b []byte) (cnt int, err error) {
 		}
 	}
 	self.offset += int64(cnt)
+	// the last bytes of a response come with io.EOF, which only ends the
+	// file when nothing was read
+	if err == io.EOF && cnt > 0 {
+		err = nil
+	}
 	if err != nil {
 		return cnt, errors.Wrap(err, "self.FileReader.Read")
 	}
 	return cnt, nil
 }
 
+// ReadAt reads len(b) bytes starting at off with a range reader of its own,
+// so it does not move the offset used by Read and is safe for concurrent use
+func (self *GcsFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	if self.fileSize > 0 && off >= self.fileSize {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	ctx, span := trace.Start(self.Ctx, "gcs.Read", objectAttributes(self.BucketName, self.FilePath, trace.String(trace.AttrRange, rangeAttribute(off, int64(len(b)))))...)
+	obj := self.Bucket.Object(self.FilePath)
+	if self.Generation != 0 {
+		// fail instead of mixing generations, see CacheKey
+		obj = obj.Generation(self.Generation)
+	}
+	r, err := obj.NewRangeReader(ctx, off, int64(len(b)))
+	if err != nil {
+		span.End(err)
+		return 0, errors.Wrap(err, "obj.NewRangeReader")
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	span.SetAttributes(trace.Int64(trace.AttrBytes, int64(n)))
+	if err == io.EOF {
+		span.End(nil)
+	} else {
+		span.End(err)
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "io.ReadFull")
+	}
+	return n, nil
+}
+
+// Size returns the object size reported by Attrs
+func (self *GcsFile) Size() int64 {
+	return self.fileSize
+}
+
+// CacheKey returns the bucket, path and generation self was opened on, as
+// used by the diskcache package. The version is empty when the generation
+// is unknown. ReadAt only reads that generation.
+func (self *GcsFile) CacheKey() (backend, bucket, object, version string) {
+	if self.Generation != 0 {
+		version = strconv.FormatInt(self.Generation, 10)
+	}
+	return "gs", self.BucketName, self.FilePath, version
+}
+
 func (self *GcsFile) Write(b []byte) (n int, err error) {
 	n, err = self.FileWriter.Write(b)
 	if err != nil {
@@ -226,7 +314,11 @@ func (self *GcsFile) Close() error {
 		}
 	}
 	if self.FileWriter != nil {
-		if err := self.FileWriter.Close(); err != nil {
+		// the upload is finalized when the writer is closed
+		_, span := trace.Start(self.Ctx, "gcs.Close", objectAttributes(self.BucketName, self.FilePath)...)
+		err := self.FileWriter.Close()
+		span.End(err)
+		if err != nil {
 			return errors.Wrap(err, "self.FileWriter.Close")
 		}
 	}
@@ -239,3 +331,21 @@ func (self *GcsFile) Close() error {
 	}
 	return nil
 }
+
+// objectAttributes returns the span attributes identifying an object
+func objectAttributes(bucket string, name string, attrs ...trace.Attribute) []trace.Attribute {
+	return append([]trace.Attribute{
+		trace.String(trace.AttrBackend, "gcs"),
+		trace.String(trace.AttrBucket, bucket),
+		trace.String(trace.AttrKey, name),
+	}, attrs...)
+}
+
+// rangeAttribute formats a range reader request, a negative length reads
+// to the end of the object
+func rangeAttribute(offset int64, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
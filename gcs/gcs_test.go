@@ -0,0 +1,187 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+	"google.golang.org/api/option"
+)
+
+const bucket = "test-bucket"
+
+type fakeObject struct {
+	data       []byte
+	generation int64
+}
+
+// fakeServer implements the parts of the JSON and XML APIs used by GcsFile:
+// object metadata, multipart uploads and ranged reads of a generation
+type fakeServer struct {
+	*httptest.Server
+
+	lock        sync.Mutex
+	objects     map[string]fakeObject
+	generations int64
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{objects: map[string]fakeObject{}}
+	s.Server = httptest.NewTLSServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) client(t *testing.T) *storage.Client {
+	client, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(s.URL+"/storage/v1/"),
+		option.WithHTTPClient(s.Client()),
+	)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/"+bucket+"/o":
+		s.upload(w, r)
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/"+bucket+"/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"+bucket+"/o/")
+		obj, ok := s.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.writeAttrs(w, name, obj)
+	case strings.HasPrefix(r.URL.Path, "/"+bucket+"/"):
+		name := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+		obj, ok := s.objects[name]
+		if !ok || (r.URL.Query().Get("generation") != "" && r.URL.Query().Get("generation") != strconv.FormatInt(obj.generation, 10)) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+		w.Header().Set("X-Goog-Metageneration", "1")
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(obj.data))
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+// upload stores a multipart/related upload: the object metadata followed
+// by its content
+func (s *fakeServer) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [2][]byte
+	for i := range parts {
+		part, err := mr.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parts[i], err = ioutil.ReadAll(part); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var metadata struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(parts[0], &metadata); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.generations++
+	obj := fakeObject{data: parts[1], generation: s.generations}
+	s.objects[metadata.Name] = obj
+	s.writeAttrs(w, metadata.Name, obj)
+}
+
+func (s *fakeServer) writeAttrs(w http.ResponseWriter, name string, obj fakeObject) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"bucket":%q,"name":%q,"size":"%d","generation":"%d"}`, bucket, name, len(obj.data), obj.generation)
+}
+
+func TestConformance(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+	ctx := context.Background()
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("conformance/%d.parquet", files)
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewGcsFileWriterWithClient(ctx, client, "project", bucket, newName(t))
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
+
+func TestReadAtGeneration(t *testing.T) {
+	server := newFakeServer(t)
+	client := server.client(t)
+	ctx := context.Background()
+
+	pf, err := NewGcsFileWriterWithClient(ctx, client, "project", bucket, "data.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := pf.Write(sourcetest.Data()); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	r, err := NewGcsFileReaderWithClient(ctx, client, "project", bucket, "data.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	// overwrite the object, ReadAt keeps the generation r was opened on
+	pf, err = pf.Create("data.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := pf.Write([]byte("overwritten")); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := r.(*GcsFile).ReadAt(make([]byte, 10), 0); err == nil {
+		t.Error("expected ReadAt of an overwritten generation to fail")
+	}
+}
@@ -11,10 +11,12 @@ require (
 	github.com/aws/aws-sdk-go-v2/config v1.5.0
 	github.com/aws/aws-sdk-go-v2/feature/s3/manager v1.3.2
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.11.1
+	github.com/aws/smithy-go v1.6.0
 	github.com/colinmarc/hdfs/v2 v2.1.1
 	github.com/golang/mock v1.4.3
 	github.com/ncw/swift v1.0.52
 	github.com/pkg/errors v0.9.1
 	github.com/sabey/parquet-go v0.0.0-20220406195015-1fe4eef2ab29
 	github.com/spf13/afero v1.2.2
+	google.golang.org/api v0.18.0
 )
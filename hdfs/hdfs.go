@@ -1,25 +1,45 @@
 package hdfs
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
 	"github.com/colinmarc/hdfs/v2"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
 type HdfsFile struct {
 	Hosts []string
 	User  string
+	// Ctx is the parent of the trace spans, context.Background() when nil
+	Ctx context.Context
 
 	Client     *hdfs.Client
 	FilePath   string
 	FileReader *hdfs.FileReader
 	FileWriter *hdfs.FileWriter
+
+	// readerAt is a second reader on the same client used by ReadAt, so
+	// that it does not move the offset of FileReader
+	readerAtLock sync.Mutex
+	readerAt     *hdfs.FileReader
 }
 
 func NewHdfsFileWriter(hosts []string, user string, name string) (source.ParquetFile, error) {
+	return NewHdfsFileWriterWithContext(context.Background(), hosts, user, name)
+}
+
+// NewHdfsFileWriterWithContext is the same as NewHdfsFileWriter, the spans of
+// the returned file are started from ctx
+func NewHdfsFileWriterWithContext(ctx context.Context, hosts []string, user string, name string) (source.ParquetFile, error) {
 	res := &HdfsFile{
 		Hosts:    hosts,
 		User:     user,
+		Ctx:      ctx,
 		FilePath: name,
 	}
 	pf, err := res.Create(name)
@@ -30,9 +50,16 @@ func NewHdfsFileWriter(hosts []string, user string, name string) (source.Parquet
 }
 
 func NewHdfsFileReader(hosts []string, user string, name string) (source.ParquetFile, error) {
+	return NewHdfsFileReaderWithContext(context.Background(), hosts, user, name)
+}
+
+// NewHdfsFileReaderWithContext is the same as NewHdfsFileReader, the spans of
+// the returned file are started from ctx
+func NewHdfsFileReaderWithContext(ctx context.Context, hosts []string, user string, name string) (source.ParquetFile, error) {
 	res := &HdfsFile{
 		Hosts:    hosts,
 		User:     user,
+		Ctx:      ctx,
 		FilePath: name,
 	}
 	pf, err := res.Open(name)
@@ -47,6 +74,7 @@ func (self *HdfsFile) Create(name string) (source.ParquetFile, error) {
 	hf := new(HdfsFile)
 	hf.Hosts = self.Hosts
 	hf.User = self.User
+	hf.Ctx = self.Ctx
 	hf.Client, err = hdfs.NewClient(hdfs.ClientOptions{
 		Addresses: hf.Hosts,
 		User:      hf.User,
@@ -55,7 +83,9 @@ func (self *HdfsFile) Create(name string) (source.ParquetFile, error) {
 	if err != nil {
 		return hf, errors.Wrap(err, "hdfs.NewClient")
 	}
+	_, span := trace.Start(hf.context(), "hdfs.Create", pathAttributes(name)...)
 	hf.FileWriter, err = hf.Client.Create(name)
+	span.End(err)
 	if err != nil {
 		return hf, errors.Wrap(err, "hf.Client.Create")
 	}
@@ -73,6 +103,7 @@ func (self *HdfsFile) Open(name string) (source.ParquetFile, error) {
 	hf := new(HdfsFile)
 	hf.Hosts = self.Hosts
 	hf.User = self.User
+	hf.Ctx = self.Ctx
 	hf.Client, err = hdfs.NewClient(hdfs.ClientOptions{
 		Addresses: hf.Hosts,
 		User:      hf.User,
@@ -81,7 +112,9 @@ func (self *HdfsFile) Open(name string) (source.ParquetFile, error) {
 	if err != nil {
 		return hf, errors.Wrap(err, "hdfs.NewClient")
 	}
+	_, span := trace.Start(hf.context(), "hdfs.Open", pathAttributes(name)...)
 	hf.FileReader, err = hf.Client.Open(name)
+	span.End(err)
 	if err != nil {
 		return hf, errors.Wrap(err, "hf.Client.Open")
 	}
@@ -96,6 +129,15 @@ func (self *HdfsFile) Seek(offset int64, pos int) (int64, error) {
 }
 
 func (self *HdfsFile) Read(b []byte) (cnt int, err error) {
+	_, span := trace.Start(self.context(), "hdfs.Read", pathAttributes(self.FilePath)...)
+	defer func() {
+		span.SetAttributes(trace.Int64(trace.AttrBytes, int64(cnt)))
+		if errors.Is(err, io.EOF) {
+			span.End(nil)
+		} else {
+			span.End(err)
+		}
+	}()
 	var n int
 	ln := len(b)
 	for cnt < ln {
@@ -105,12 +147,48 @@ func (self *HdfsFile) Read(b []byte) (cnt int, err error) {
 			break
 		}
 	}
+	// io.EOF only ends the file when nothing was read
+	if err == io.EOF && cnt > 0 {
+		err = nil
+	}
 	if err != nil {
 		return cnt, errors.Wrap(err, "self.FileReader.Read")
 	}
 	return cnt, nil
 }
 
+// ReadAt reads len(b) bytes starting at off without moving the offset used
+// by Read. Concurrent calls are serialized on a reader opened with the
+// existing client, instead of a new client per handle.
+func (self *HdfsFile) ReadAt(b []byte, off int64) (int, error) {
+	self.readerAtLock.Lock()
+	defer self.readerAtLock.Unlock()
+
+	if self.readerAt == nil {
+		fr, err := self.Client.Open(self.FilePath)
+		if err != nil {
+			return 0, errors.Wrap(err, "self.Client.Open")
+		}
+		self.readerAt = fr
+	}
+
+	_, span := trace.Start(self.context(), "hdfs.ReadAt", pathAttributes(self.FilePath, trace.String(trace.AttrRange, fmt.Sprintf("bytes=%d-%d", off, off+int64(len(b))-1)))...)
+	n, err := self.readerAt.ReadAt(b, off)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, int64(n)))
+	if err == io.EOF {
+		span.End(nil)
+	} else {
+		span.End(err)
+	}
+	if err != nil {
+		if err == io.EOF {
+			return n, errors.Wrap(err, "io.EOF")
+		}
+		return n, errors.Wrap(err, "self.readerAt.ReadAt")
+	}
+	return n, nil
+}
+
 func (self *HdfsFile) Write(b []byte) (n int, err error) {
 	n, err = self.FileWriter.Write(b)
 	if err != nil {
@@ -123,11 +201,32 @@ func (self *HdfsFile) Close() error {
 	if self.FileReader != nil {
 		self.FileReader.Close()
 	}
+	if self.readerAt != nil {
+		self.readerAt.Close()
+	}
 	if self.FileWriter != nil {
-		self.FileWriter.Close()
+		// the last block is flushed when the writer is closed
+		_, span := trace.Start(self.context(), "hdfs.Close", pathAttributes(self.FilePath)...)
+		span.End(self.FileWriter.Close())
 	}
 	if self.Client != nil {
 		self.Client.Close()
 	}
 	return nil
 }
+
+// context returns the parent of the spans of the file
+func (self *HdfsFile) context() context.Context {
+	if self.Ctx == nil {
+		return context.Background()
+	}
+	return self.Ctx
+}
+
+// pathAttributes returns the span attributes identifying a file
+func pathAttributes(name string, attrs ...trace.Attribute) []trace.Attribute {
+	return append([]trace.Attribute{
+		trace.String(trace.AttrBackend, "hdfs"),
+		trace.String(trace.AttrKey, name),
+	}, attrs...)
+}
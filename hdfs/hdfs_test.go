@@ -0,0 +1,54 @@
+package hdfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+// TestConformance runs against the namenodes listed in HDFS_NAMENODE,
+// separated by commas, as the user in HDFS_USER. There is no in-process
+// fake of the namenode and datanode protocols, so hdfs is the one backend
+// left out of the suite when it is unset.
+func TestConformance(t *testing.T) {
+	namenode := os.Getenv("HDFS_NAMENODE")
+	if namenode == "" {
+		t.Skip("HDFS_NAMENODE is not set")
+	}
+	hosts := strings.Split(namenode, ",")
+	user := os.Getenv("HDFS_USER")
+
+	client, err := hdfs.NewClient(hdfs.ClientOptions{Addresses: hosts, User: user})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	defer client.Close()
+	dir := fmt.Sprintf("/tmp/parquet-go-source-%d", time.Now().UnixNano())
+	if err := client.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	defer client.RemoveAll(dir)
+
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("%s/%d.parquet", dir, files)
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewHdfsFileWriter(hosts, user, newName(t))
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
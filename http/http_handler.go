@@ -0,0 +1,152 @@
+package http
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+// versioned is implemented by the sources naming the version they read,
+// such as the s3, s3v2, gcs and azblob readers
+type versioned interface {
+	CacheKey() (backend, bucket, object, version string)
+}
+
+// HandlerOptions configure NewHandler
+type HandlerOptions struct {
+	// ETag is sent with every response and compared with the If-Range,
+	// If-Match and If-None-Match headers, it must change with the file.
+	// When empty it is derived from the version of the handle serving each
+	// request, for sources naming one. Other sources are served without an
+	// ETag, so clients cannot rely on it to combine ranges.
+	ETag string
+	// ModTime is sent as Last-Modified when not zero
+	ModTime time.Time
+	// ContentType defaults to application/octet-stream
+	ContentType string
+	// ErrorHook is called with the errors of the file, which are answered
+	// with a bare 502 Bad Gateway so that their details stay on the server
+	ErrorHook func(r *http.Request, err error)
+}
+
+// Handler serves a ParquetFile to HttpReader clients, or any HTTP client
+// using range requests
+type Handler struct {
+	pf      source.ParquetFile
+	options HandlerOptions
+}
+
+// NewHandler returns a handler answering GET and HEAD requests with the
+// content of pf. Every request reads from its own handle returned by
+// pf.Open(""), so pf must be opened for reading.
+func NewHandler(pf source.ParquetFile, options HandlerOptions) *Handler {
+	if options.ContentType == "" {
+		options.ContentType = "application/octet-stream"
+	}
+	return &Handler{
+		pf:      pf,
+		options: options,
+	}
+}
+
+// ServeHTTP handles Range, If-Range and the other conditional headers with
+// http.ServeContent
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	pf, err := h.pf.Open("")
+	if err != nil {
+		h.fail(w, r, errors.Wrap(err, "h.pf.Open"))
+		return
+	}
+	defer pf.Close()
+
+	size, err := sourceutil.Size(pf)
+	if err != nil {
+		h.fail(w, r, errors.Wrap(err, "sourceutil.Size"))
+		return
+	}
+	if etag := h.getETag(pf); etag != "" {
+		w.Header().Set(etagHeader, etag)
+	}
+	w.Header().Set("Content-Type", h.options.ContentType)
+	http.ServeContent(w, r, "", h.options.ModTime, &readSeeker{pf: pf, size: size})
+}
+
+// fail reports err to the ErrorHook and answers with a 502 that does not
+// leak it to the client
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.options.ErrorHook != nil {
+		h.options.ErrorHook(r, err)
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+// getETag returns the ETag of the options, or derives one from the version
+// of pf. It is derived again for every handle, as the file may have changed
+// since the previous one was opened. It is empty when pf names no version.
+func (h *Handler) getETag(pf source.ParquetFile) string {
+	if h.options.ETag != "" {
+		return h.options.ETag
+	}
+	v, ok := pf.(versioned)
+	if !ok {
+		return ""
+	}
+	backend, bucket, object, version := v.CacheKey()
+	if version == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(backend + "\x00" + bucket + "\x00" + object + "\x00" + version))
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// readSeeker lets http.ServeContent read a ParquetFile, it keeps the offset
+// itself since Seek(0, io.SeekEnd) does not return the size on every source
+type readSeeker struct {
+	pf     source.ParquetFile
+	size   int64
+	offset int64
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Seek: invalid offset")
+	}
+	r.offset = offset
+	return r.offset, nil
+}
+
+func (r *readSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.offset {
+		p = p[:r.size-r.offset]
+	}
+	n, err := sourceutil.ReadAt(r.pf, p, r.offset)
+	r.offset += int64(n)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(p)) {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+func TestHandlerConformance(t *testing.T) {
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			server := httptest.NewServer(NewHandler(buffer.NewBufferFileFromBytes(data), HandlerOptions{ETag: `"v1"`}))
+			t.Cleanup(server.Close)
+
+			pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+	})
+}
+
+func TestHandler(t *testing.T) {
+	data := sourcetest.Data()
+	server := httptest.NewServer(NewHandler(buffer.NewBufferFileFromBytes(data), HandlerOptions{ETag: `"v1"`}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		method       string
+		header       map[string]string
+		status       int
+		contentRange string
+		length       int
+	}{
+		{http.MethodHead, nil, http.StatusOK, "", len(data)},
+		{http.MethodGet, nil, http.StatusOK, "", len(data)},
+		{http.MethodGet, map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, "bytes 10-19/1000", 10},
+		{http.MethodGet, map[string]string{"Range": "bytes=-5"}, http.StatusPartialContent, "bytes 995-999/1000", 5},
+		{http.MethodGet, map[string]string{"Range": "bytes=10-19", "If-Range": `"v1"`}, http.StatusPartialContent, "bytes 10-19/1000", 10},
+		{http.MethodGet, map[string]string{"Range": "bytes=10-19", "If-Range": `"v0"`}, http.StatusOK, "", len(data)},
+		{http.MethodGet, map[string]string{"If-Match": `"v0"`}, http.StatusPreconditionFailed, "", -1},
+		{http.MethodGet, map[string]string{"Range": "bytes=2000-"}, http.StatusRequestedRangeNotSatisfiable, "bytes */1000", -1},
+		{http.MethodPost, nil, http.StatusMethodNotAllowed, "", -1},
+	} {
+		req, err := http.NewRequest(test.method, server.URL, nil)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %v: expected status %d but got %d", test.method, test.header, test.status, resp.StatusCode)
+			continue
+		}
+		if got := resp.Header.Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s %v: expected Content-Range %q but got %q", test.method, test.header, test.contentRange, got)
+		}
+		if test.length < 0 {
+			continue
+		}
+		if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(test.length) {
+			t.Errorf("%s %v: expected Content-Length %d but got %s", test.method, test.header, test.length, got)
+		}
+		if resp.Header.Get("ETag") != `"v1"` || resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("%s %v: expected ETag and Accept-Ranges headers but got %v", test.method, test.header, resp.Header)
+		}
+		if test.method == http.MethodGet && len(body) != test.length {
+			t.Errorf("%s %v: expected %d bytes but got %d", test.method, test.header, test.length, len(body))
+		}
+	}
+}
+
+func TestHandlerNoETag(t *testing.T) {
+	data := sourcetest.Data()
+	server := httptest.NewServer(NewHandler(buffer.NewBufferFileFromBytes(data), HandlerOptions{}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	req.Header.Set("Range", "bytes=10-19")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[10:20]) {
+		t.Errorf("expected a 206 of bytes 10-19 but got %d", resp.StatusCode)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag for a source without a version but got %q", etag)
+	}
+}
+
+// versionedFile serves the current data and version of a remote object,
+// every handle returned by Open reads the version current at that time
+type versionedFile struct {
+	*buffer.BufferFile
+	object  *versionedObject
+	version string
+}
+
+type versionedObject struct {
+	data    []byte
+	version string
+}
+
+func (f versionedFile) Open(string) (source.ParquetFile, error) {
+	return versionedFile{BufferFile: buffer.NewBufferFileFromBytes(f.object.data), object: f.object, version: f.object.version}, nil
+}
+
+func (f versionedFile) CacheKey() (backend, bucket, object, version string) {
+	return "test", "bucket", "file.parquet", f.version
+}
+
+func TestHandlerVersionETag(t *testing.T) {
+	data := sourcetest.Data()
+	object := &versionedObject{data: data, version: "1"}
+	server := httptest.NewServer(NewHandler(versionedFile{object: object}, HandlerOptions{}))
+	defer server.Close()
+
+	get := func(etag string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		req.Header.Set("Range", "bytes=10-19")
+		if etag != "" {
+			req.Header.Set("If-Range", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, body
+	}
+
+	resp, _ := get("")
+	etag := resp.Header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag to be derived from the version but got %q", etag)
+	}
+	if resp, _ = get(etag); resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected status %d but got %d", http.StatusPartialContent, resp.StatusCode)
+	}
+
+	// the object is replaced, a range of the old ETag must not be served
+	changed := bytes.ToUpper(data)
+	object.data, object.version = changed, "2"
+	resp, body := get(etag)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("expected the ETag to change with the version")
+	}
+	if !bytes.Equal(body, changed) {
+		t.Errorf("expected the whole new file to be served")
+	}
+}
+
+type failingOpenFile struct {
+	source.ParquetFile
+}
+
+func (failingOpenFile) Open(string) (source.ParquetFile, error) {
+	return nil, errors.New("secret backend details")
+}
+
+func TestHandlerError(t *testing.T) {
+	var hooked error
+	server := httptest.NewServer(NewHandler(failingOpenFile{}, HandlerOptions{
+		ErrorHook: func(_ *http.Request, err error) { hooked = err },
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected status %d but got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+	if strings.Contains(string(body), "secret") {
+		t.Errorf("expected the error not to be sent to the client but got %q", body)
+	}
+	if hooked == nil || !strings.Contains(hooked.Error(), "secret backend details") {
+		t.Errorf("expected the error to be passed to the hook but got %v", hooked)
+	}
+}
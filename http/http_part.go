@@ -0,0 +1,89 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go/source"
+)
+
+// DefaultPartMaxMemory is the number of bytes of a part kept in memory when
+// PartOptions.MaxMemory is unset
+const DefaultPartMaxMemory = 32 << 20
+
+var (
+	// ErrPartTooLarge is returned when a part is longer than PartOptions.MaxSize
+	ErrPartTooLarge = errors.New("multipart part is too large")
+	// ErrNotParquet is returned when a part does not start and end with the
+	// parquet magic bytes
+	ErrNotParquet = errors.New("multipart part is not a parquet file")
+)
+
+var parquetMagic = []byte("PAR1")
+
+// PartOptions configure NewPartFile
+type PartOptions struct {
+	// MaxMemory is the number of bytes kept in memory, the rest of the part
+	// is spooled to a temporary file
+	MaxMemory int64
+	// MaxSize is the size of the largest part accepted, 0 has no limit
+	MaxSize int64
+	// Dir is the directory of the temporary file, os.TempDir is used when
+	// empty
+	Dir string
+}
+
+// NewPartFile reads a part of a streamed multipart request, as returned by
+// multipart.Reader.NextPart, without parsing the whole form first. The part
+// is rejected with ErrNotParquet as soon as its first bytes are read if they
+// are not the parquet magic, and with ErrPartTooLarge once more than MaxSize
+// bytes are read. The returned file must be closed to remove the temporary
+// file.
+func NewPartFile(part *multipart.Part, options PartOptions) (source.ParquetFile, error) {
+	if options.MaxMemory <= 0 {
+		options.MaxMemory = DefaultPartMaxMemory
+	}
+
+	s, err := newSpool(&magicReader{reader: part}, options.Dir, options.MaxMemory, options.MaxSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "newSpool")
+	}
+	pf := &SpooledHttpReader{spool: s}
+
+	footer := make([]byte, len(parquetMagic))
+	if _, err := s.data.ReadAt(footer, s.size-int64(len(footer))); err != nil || !bytes.Equal(footer, parquetMagic) {
+		pf.Close()
+		return nil, errors.Wrap(ErrNotParquet, "ErrNotParquet")
+	}
+	return pf, nil
+}
+
+// magicReader fails with ErrNotParquet as soon as the first bytes read do
+// not match the parquet magic, or when the stream is shorter than a header
+// and a footer
+type magicReader struct {
+	reader io.Reader
+	head   []byte
+	read   int64
+}
+
+func (r *magicReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if len(r.head) < len(parquetMagic) {
+		m := len(parquetMagic) - len(r.head)
+		if m > n {
+			m = n
+		}
+		r.head = append(r.head, p[:m]...)
+		if !bytes.HasPrefix(parquetMagic, r.head) {
+			return n, errors.Wrap(ErrNotParquet, "ErrNotParquet")
+		}
+	}
+	r.read += int64(n)
+	if err == io.EOF && r.read < int64(2*len(parquetMagic)) {
+		return n, errors.Wrap(ErrNotParquet, "ErrNotParquet")
+	}
+	return n, err
+}
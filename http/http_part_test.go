@@ -0,0 +1,121 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/writer"
+)
+
+// nextPart returns the part of a multipart body holding data
+func nextPart(t *testing.T, data []byte) *multipart.Part {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "data.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	fw.Write(data)
+	mw.Close()
+
+	part, err := multipart.NewReader(&body, mw.Boundary()).NextPart()
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return part
+}
+
+func parquetData(t *testing.T) []byte {
+	bf := buffer.NewBufferFile()
+	pw, err := writer.NewParquetWriter(bf, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		if err := pw.Write(sourcetest.Student{Name: "StudentName", ID: int64(i)}); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return bf.Bytes()
+}
+
+func TestPartFile(t *testing.T) {
+	data := parquetData(t)
+	for name, maxMemory := range map[string]int64{
+		"memory": 0,
+		"file":   100,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			pf, err := NewPartFile(nextPart(t, data), PartOptions{MaxMemory: maxMemory, Dir: dir})
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			spooled := 0
+			if maxMemory > 0 {
+				spooled = 1
+			}
+			if files, _ := ioutil.ReadDir(dir); len(files) != spooled {
+				t.Errorf("expected %d temporary files but got %d", spooled, len(files))
+			}
+
+			pr, err := reader.NewParquetReader(pf, new(sourcetest.Student), 2)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if n := pr.GetNumRows(); n != 100 {
+				t.Errorf("expected 100 rows but got %d", n)
+			}
+			pr.ReadStop()
+
+			if err := pf.Close(); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+				t.Errorf("expected the temporary file to be removed but got %d files", len(files))
+			}
+		})
+	}
+}
+
+func TestPartFileRejected(t *testing.T) {
+	data := parquetData(t)
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		options PartOptions
+		err     error
+	}{
+		{"header", append([]byte("CSV1"), data[4:]...), PartOptions{MaxMemory: 10}, ErrNotParquet},
+		{"footer", data[:len(data)-1], PartOptions{MaxMemory: 10}, ErrNotParquet},
+		{"short", []byte("PAR1"), PartOptions{}, ErrNotParquet},
+		{"too large in memory", data, PartOptions{MaxSize: 100}, ErrPartTooLarge},
+		{"too large on disk", data, PartOptions{MaxMemory: 10, MaxSize: int64(len(data)) - 1}, ErrPartTooLarge},
+		{"max size", data, PartOptions{MaxMemory: 10, MaxSize: int64(len(data))}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.Dir = t.TempDir()
+			pf, err := NewPartFile(nextPart(t, test.data), test.options)
+			if test.err == nil {
+				if err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				pf.Close()
+			} else if !errors.Is(err, test.err) {
+				t.Errorf("expected error to be %v but got %v", test.err, err)
+			}
+			if files, _ := ioutil.ReadDir(test.options.Dir); len(files) != 0 {
+				t.Errorf("expected no temporary file to be left but got %d", len(files))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -8,49 +9,196 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
 type HttpReader struct {
-	url          string
-	size         int64
-	offset       int64
-	httpClient   *http.Client
-	extraHeaders map[string]string
+	url     string
+	size    int64
+	offset  int64
+	options HttpReaderOptions
+	// etag and lastModified identify the representation returned by the
+	// initial request, the ranged requests are conditional on them
+	etag         string
+	lastModified string
+}
+
+// HttpReaderOptions configure NewHttpReaderWithOptions
+type HttpReaderOptions struct {
+	// Context is the context of every request and the parent of their
+	// trace spans, context.Background() is used when nil
+	Context context.Context
+	// Client sends every request, http.DefaultClient is used when nil
+	Client *http.Client
+	// Headers are added to every request
+	Headers map[string]string
+	// RequestHook is called on every request right before it is sent, once
+	// all the other headers are set, to add authentication such as a
+	// refreshed bearer token or a signature
+	RequestHook func(req *http.Request) error
+	// Fallback enables the HEAD and spooling fallback of
+	// NewHttpReaderWithFallback for servers without range support
+	Fallback *FallbackOptions
+}
+
+// ObjectChangedError is returned when the remote file no longer matches the
+// ETag or Last-Modified date seen when the HttpReader was created
+type ObjectChangedError struct {
+	URL string
+	// Expected and Got are the ETags, or the Last-Modified dates when the
+	// server does not send a strong ETag
+	Expected string
+	Got      string
+}
+
+func (e *ObjectChangedError) Error() string {
+	return fmt.Sprintf("remote [%s] changed: expected %q but got %q", e.URL, e.Expected, e.Got)
+}
 
-	dedicatedTransport bool
+// StatusError is returned when the server answers with a 4xx or 5xx status
+type StatusError struct {
+	URL    string
+	Code   int
+	Status string
+}
+
+func newStatusError(uri string, resp *http.Response) *StatusError {
+	return &StatusError{URL: uri, Code: resp.StatusCode, Status: resp.Status}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remote [%s] returned %s", e.URL, e.Status)
+}
+
+// StatusCode returns the HTTP status of the response
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+// NotFound reports whether the remote file does not exist
+func (e *StatusError) NotFound() bool {
+	return e.Code == http.StatusNotFound || e.Code == http.StatusGone
 }
 
 const (
-	rangeHeader        = "Range"
-	rangeFormat        = "bytes=%d-%d"
-	contentRangeHeader = "Content-Range"
+	rangeHeader             = "Range"
+	rangeFormat             = "bytes=%d-%d"
+	contentRangeHeader      = "Content-Range"
+	acceptRangesHeader      = "Accept-Ranges"
+	etagHeader              = "ETag"
+	lastModifiedHeader      = "Last-Modified"
+	ifRangeHeader           = "If-Range"
+	ifMatchHeader           = "If-Match"
+	ifUnmodifiedSinceHeader = "If-Unmodified-Since"
 )
 
 func NewHttpReader(uri string, dedicatedTransport, ignoreTLSError bool, extraHeaders map[string]string) (source.ParquetFile, error) {
-	// make sure remote support range
-	transport := http.DefaultTransport
+	pf, err := NewHttpReaderWithOptions(uri, HttpReaderOptions{
+		Client:  newClient(dedicatedTransport, ignoreTLSError),
+		Headers: extraHeaders,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "NewHttpReaderWithOptions")
+	}
+	return pf, nil
+}
+
+// NewHttpReaderWithFallback also reads from servers without range support:
+// the size is taken from a HEAD request when the server advertises ranges,
+// and when it ignores the Range header the file is downloaded once and
+// served by a SpooledHttpReader
+func NewHttpReaderWithFallback(uri string, dedicatedTransport, ignoreTLSError bool, extraHeaders map[string]string, fallback FallbackOptions) (source.ParquetFile, error) {
+	pf, err := NewHttpReaderWithOptions(uri, HttpReaderOptions{
+		Client:   newClient(dedicatedTransport, ignoreTLSError),
+		Headers:  extraHeaders,
+		Fallback: &fallback,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "NewHttpReaderWithOptions")
+	}
+	return pf, nil
+}
+
+var (
+	insecureTransportOnce sync.Once
+	insecureTransport     *http.Transport
+)
+
+// newClient returns the client of the boolean based constructors. The shared
+// transports are never modified: the insecure one is a clone of
+// http.DefaultTransport.
+func newClient(dedicatedTransport, ignoreTLSError bool) *http.Client {
 	if dedicatedTransport {
-		transport = &http.Transport{}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: ignoreTLSError}
+		return &http.Client{Transport: transport}
+	}
+	if !ignoreTLSError {
+		return http.DefaultClient
+	}
+
+	insecureTransportOnce.Do(func() {
+		insecureTransport = http.DefaultTransport.(*http.Transport).Clone()
+		insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	})
+	return &http.Client{Transport: insecureTransport}
+}
+
+// NewHttpReaderWithOptions returns a reader of uri sending its requests with
+// options.Client
+func NewHttpReaderWithOptions(uri string, options HttpReaderOptions) (source.ParquetFile, error) {
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
 	}
-	transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: ignoreTLSError}
 
-	client := &http.Client{Transport: transport}
-	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	r := &HttpReader{
+		url:     uri,
+		options: options,
+	}
+	if options.Fallback != nil {
+		ok, err := r.head()
+		if err != nil {
+			return nil, errors.Wrap(err, "r.head")
+		}
+		if ok {
+			return r, nil
+		}
+	}
+
+	// make sure remote support range
+	req, err := r.newRequest(http.MethodGet, http.Header{rangeHeader: {fmt.Sprintf(rangeFormat, 0, 0)}})
 	if err != nil {
-		return nil, errors.Wrap(err, "http.NewRequest")
+		return nil, errors.Wrap(err, "r.newRequest")
 	}
-	for k, v := range extraHeaders {
-		req.Header.Add(k, v)
+	ctx, span := trace.Start(req.Context(), "http.GET", urlAttributes(uri, req.Header.Get(rangeHeader))...)
+	resp, err := r.options.Client.Do(req.WithContext(ctx))
+	if resp != nil {
+		span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
 	}
-	req.Header.Add(rangeHeader, fmt.Sprintf(rangeFormat, 0, 0))
-	resp, err := client.Do(req)
+	span.End(err)
 	if err != nil {
 		return nil, errors.Wrap(err, "client.Do")
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, newStatusError(uri, resp)
+	}
+
+	// the range was ignored, the body is the whole file
+	if options.Fallback != nil && resp.StatusCode == http.StatusOK {
+		pf, err := newSpooledHttpReader(resp.Body, *options.Fallback)
+		if err != nil {
+			return nil, errors.Wrap(err, "newSpooledHttpReader")
+		}
+		return pf, nil
+	}
 
 	// retrieve size
 	contentRange := resp.Header.Values(contentRangeHeader)
@@ -68,29 +216,61 @@ func NewHttpReader(uri string, dedicatedTransport, ignoreTLSError bool, extraHea
 		return nil, errors.Wrapf(err, "unable to parse data size from %s: %s", contentRangeHeader, contentRange[0])
 	}
 
-	return &HttpReader{
-		url:                uri,
-		size:               size,
-		offset:             0,
-		httpClient:         client,
-		extraHeaders:       extraHeaders,
-		dedicatedTransport: dedicatedTransport,
-	}, nil
+	r.size = size
+	r.etag = resp.Header.Get(etagHeader)
+	r.lastModified = resp.Header.Get(lastModifiedHeader)
+	return r, nil
+}
+
+// head sets the size of the remote file from a HEAD request, it returns
+// false when the server does not answer HEAD or does not advertise ranges
+func (r *HttpReader) head() (bool, error) {
+	req, err := r.newRequest(http.MethodHead, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "r.newRequest")
+	}
+	ctx, span := trace.Start(req.Context(), "http.HEAD", urlAttributes(r.url, "")...)
+	resp, err := r.options.Client.Do(req.WithContext(ctx))
+	if resp != nil {
+		span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
+	}
+	span.End(err)
+	if err != nil {
+		return false, errors.Wrap(err, "r.options.Client.Do")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 || resp.Header.Get(acceptRangesHeader) != "bytes" {
+		return false, nil
+	}
+	r.size = resp.ContentLength
+	r.etag = resp.Header.Get(etagHeader)
+	r.lastModified = resp.Header.Get(lastModifiedHeader)
+	return true, nil
 }
 
 func (r *HttpReader) Create(_ string) (source.ParquetFile, error) {
 	return nil, fmt.Errorf("HttpReader does not support Create()")
 }
 
+// Open returns a new reader of the same remote file, it fails with an
+// ObjectChangedError when the file changed since r was created
 func (r *HttpReader) Open(_ string) (source.ParquetFile, error) {
-	pf, err := NewHttpReader(
-		r.url,
-		r.dedicatedTransport,
-		r.httpClient.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify,
-		r.extraHeaders,
-	)
+	pf, err := NewHttpReaderWithOptions(r.url, r.options)
 	if err != nil {
-		return pf, errors.Wrap(err, "NewHttpReader")
+		return pf, errors.Wrap(err, "NewHttpReaderWithOptions")
+	}
+	opened, ok := pf.(*HttpReader)
+	if !ok {
+		pf.Close()
+		return nil, fmt.Errorf("remote [%s] no longer supports range", r.url)
+	}
+	if opened.etag != r.etag || opened.lastModified != r.lastModified {
+		expected, got := r.etag, opened.etag
+		if expected == got {
+			expected, got = r.lastModified, opened.lastModified
+		}
+		return nil, &ObjectChangedError{URL: r.url, Expected: expected, Got: got}
 	}
 	return pf, nil
 }
@@ -98,53 +278,250 @@ func (r *HttpReader) Open(_ string) (source.ParquetFile, error) {
 func (r *HttpReader) Seek(offset int64, pos int) (int64, error) {
 	switch pos {
 	case io.SeekStart:
-		r.offset = offset
 	case io.SeekCurrent:
-		r.offset += offset
+		offset += r.offset
 	case io.SeekEnd:
-		r.offset = r.size + offset
+		offset += r.size
 	default:
 		return 0, fmt.Errorf("unknown whence: %d", pos)
 	}
 
-	if r.offset < 0 {
-		r.offset = 0
-	} else if r.offset >= r.size {
-		r.offset = r.size
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid offset: %d", offset)
+	} else if offset >= r.size {
+		offset = r.size
 	}
 
+	r.offset = offset
 	return r.offset, nil
 }
 
+// Read reads len(b) bytes at the current offset, io.EOF is returned when b
+// could not be filled
 func (r *HttpReader) Read(b []byte) (int, error) {
-	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	n, err := r.ReadAt(b, r.offset)
+	r.offset += int64(n)
 	if err != nil {
-		return 0, errors.Wrap(err, "http.NewRequest")
+		return n, errors.Wrap(err, "r.ReadAt")
 	}
+	return n, nil
+}
 
-	for k, v := range r.extraHeaders {
-		req.Header.Add(k, v)
+// ReadAt reads len(b) bytes starting at off without moving the offset used
+// by Read, it is safe for concurrent use. io.EOF is returned when b could not
+// be filled.
+func (r *HttpReader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset: %d", off)
+	}
+	if off >= r.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+	if len(b) == 0 {
+		return 0, nil
 	}
-	req.Header.Add(rangeHeader, fmt.Sprintf(rangeFormat, r.offset, r.offset+int64(len(b)-1)))
-	resp, err := r.httpClient.Do(req)
+
+	length := int64(len(b))
+	if off+length > r.size {
+		length = r.size - off
+	}
+	resp, err := r.getRange(off, length)
 	if err != nil {
-		return 0, errors.Wrap(err, "r.httpClient.Do")
+		return 0, errors.Wrap(err, "r.getRange")
 	}
+	defer resp.Body.Close()
 
-	buf, err := ioutil.ReadAll(resp.Body)
-	bytesRead := len(buf)
-	for i := 0; i < bytesRead; i++ {
-		b[i] = buf[i]
+	// the response is shorter than the requested range
+	n, err := io.ReadFull(resp.Body, b[:length])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
+	if err != nil {
+		return n, errors.Wrap(err, "io.ReadFull")
+	}
+	if n < len(b) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
 
-	r.offset += int64(bytesRead)
-	if r.offset > r.size {
-		r.offset = r.size
+// newRequest returns a request of the remote file with the configured
+// headers, header and whatever RequestHook adds
+func (r *HttpReader) newRequest(method string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.options.Context, method, r.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequestWithContext")
+	}
+	for k, v := range r.options.Headers {
+		req.Header.Add(k, v)
 	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if r.options.RequestHook != nil {
+		if err := r.options.RequestHook(req); err != nil {
+			return nil, errors.Wrap(err, "r.options.RequestHook")
+		}
+	}
+	return req, nil
+}
+
+// getRange requests length bytes of the remote file starting at offset. The
+// body of the returned response starts at offset and is at most length bytes
+// long, a server answering with the whole file is accepted.
+func (r *HttpReader) getRange(offset int64, length int64) (*http.Response, error) {
+	header := http.Header{rangeHeader: {fmt.Sprintf(rangeFormat, offset, offset+length-1)}}
+	if r.strongETag() {
+		header.Set(ifRangeHeader, r.etag)
+		header.Set(ifMatchHeader, r.etag)
+	} else if r.lastModified != "" {
+		header.Set(ifRangeHeader, r.lastModified)
+		header.Set(ifUnmodifiedSinceHeader, r.lastModified)
+	}
+	req, err := r.newRequest(http.MethodGet, header)
+	if err != nil {
+		return nil, errors.Wrap(err, "r.newRequest")
+	}
+	ctx, span := trace.Start(req.Context(), "http.GET", urlAttributes(r.url, req.Header.Get(rangeHeader))...)
+	resp, err := r.options.Client.Do(req.WithContext(ctx))
 	if err != nil {
-		return bytesRead, errors.Wrap(err, "ioutil.ReadAll")
+		span.End(err)
+		return nil, errors.Wrap(err, "r.options.Client.Do")
 	}
-	return bytesRead, nil
+	span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
+	if err := r.checkUnchanged(resp); err != nil {
+		span.End(err)
+		resp.Body.Close()
+		return nil, err
+	}
+	body := &tracedBody{ReadCloser: resp.Body, span: span}
+	resp.Body = body
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, end, size, err := parseContentRange(resp.Header.Get(contentRangeHeader))
+		if err != nil {
+			resp.Body.Close()
+			return nil, errors.Wrap(err, "parseContentRange")
+		}
+		if start != offset || end != offset+length-1 || (size >= 0 && size != r.size) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("remote [%s] returned %s %s for range %s", r.url, contentRangeHeader, resp.Header.Get(contentRangeHeader), req.Header.Get(rangeHeader))
+		}
+	case http.StatusOK:
+		if resp.ContentLength >= 0 && resp.ContentLength != r.size {
+			resp.Body.Close()
+			return nil, fmt.Errorf("remote [%s] returned %d bytes for a file of %d bytes", r.url, resp.ContentLength, r.size)
+		}
+		// the range was ignored, skip to offset
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, errors.Wrap(err, "io.CopyN")
+		}
+	default:
+		err := newStatusError(r.url, resp)
+		body.err = err
+		resp.Body.Close()
+		return nil, err
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, length), body}
+	return resp, nil
+}
+
+// parseContentRange returns the first and last bytes and the size of a
+// "bytes start-end/size" Content-Range, the size is -1 when it is "*"
+func parseContentRange(contentRange string) (int64, int64, int64, error) {
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, 0, 0, fmt.Errorf("%s format is unknown: %s", contentRangeHeader, contentRange)
+	}
+	size := int64(-1)
+	if total != "*" {
+		var err error
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("%s is invalid: %s", contentRangeHeader, contentRange)
+		}
+	}
+	if start < 0 || end < start || (size >= 0 && end >= size) {
+		return 0, 0, 0, fmt.Errorf("%s is invalid: %s", contentRangeHeader, contentRange)
+	}
+	return start, end, size, nil
+}
+
+// strongETag reports whether the ETag of the remote file can be used in
+// If-Range and If-Match, which only accept strong validators
+func (r *HttpReader) strongETag() bool {
+	return r.etag != "" && !strings.HasPrefix(r.etag, "W/")
+}
+
+// checkUnchanged returns an ObjectChangedError when resp is not a part of the
+// representation r was created with: the server rejected the precondition,
+// ignored the range because If-Range did not match, or sent other
+// validators. A 200 with the same validators is a server ignoring the range,
+// one without validators cannot be told apart from another representation.
+func (r *HttpReader) checkUnchanged(resp *http.Response) error {
+	expected, got := r.lastModified, resp.Header.Get(lastModifiedHeader)
+	if r.strongETag() {
+		expected, got = r.etag, resp.Header.Get(etagHeader)
+	}
+	if expected == "" {
+		return nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed:
+	case got != "" && got != expected:
+	case resp.StatusCode == http.StatusOK && got == "":
+	default:
+		return nil
+	}
+	return &ObjectChangedError{URL: r.url, Expected: expected, Got: got}
+}
+
+// tracedBody ends the span of a request once its body has been read
+type tracedBody struct {
+	io.ReadCloser
+	span  trace.Span
+	bytes int64
+	err   error
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.span != nil {
+		b.span.SetAttributes(trace.Int64(trace.AttrBytes, b.bytes))
+		b.span.End(b.err)
+		b.span = nil
+	}
+	return err
+}
+
+// urlAttributes returns the span attributes of a request
+func urlAttributes(uri string, byteRange string) []trace.Attribute {
+	return []trace.Attribute{
+		trace.String(trace.AttrBackend, "http"),
+		trace.String(trace.AttrKey, uri),
+		trace.String(trace.AttrRange, byteRange),
+	}
+}
+
+// Size returns the size in bytes of the remote file
+func (r *HttpReader) Size() int64 {
+	return r.size
 }
 
 func (r *HttpReader) Write(_ []byte) (int, error) {
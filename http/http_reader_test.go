@@ -1,10 +1,22 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go-source/trace"
+	"github.com/sabey/parquet-go-source/trace/tracetest"
 )
 
 func Test_http_reader_no_range_support(t *testing.T) {
@@ -32,3 +44,393 @@ func Test_http_reader_no_range_support(t *testing.T) {
 		}
 	}
 }
+
+func TestReadAt(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	ra := pf.(io.ReaderAt)
+
+	b := make([]byte, 100)
+	n, err := ra.ReadAt(b, 450)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n != 100 || !bytes.Equal(b, data[450:550]) {
+		t.Errorf("expected ReadAt to return bytes 450-549 but got %d bytes", n)
+	}
+
+	n, err = ra.ReadAt(b, 950)
+	if n != 50 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected 50 bytes and io.EOF but got %d and %v", n, err)
+	}
+	if !bytes.Equal(b[:n], data[950:]) {
+		t.Error("expected the short ReadAt to return the end of the file")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewRecorder()
+	trace.SetTracer(recorder)
+	defer trace.SetTracer(nil)
+
+	data := make([]byte, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = pf.Seek(10, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = pf.Read(make([]byte, 20)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	spans := recorder.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans but got %v", recorder.Names())
+	}
+	read := spans[1]
+	if read.Name != "http.GET" || read.Err != nil {
+		t.Errorf("expected a successful http.GET span but got %s (%v)", read.Name, read.Err)
+	}
+	for key, value := range map[string]interface{}{
+		trace.AttrKey:        server.URL,
+		trace.AttrRange:      "bytes=10-29",
+		trace.AttrBytes:      int64(20),
+		trace.AttrStatusCode: int64(http.StatusPartialContent),
+	} {
+		if read.Attributes[key] != value {
+			t.Errorf("expected attribute %s to be %v but got %v", key, value, read.Attributes[key])
+		}
+	}
+}
+
+func TestObjectChanged(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		serve func(w http.ResponseWriter, r *http.Request, version int)
+	}{
+		{"ETag", func(w http.ResponseWriter, r *http.Request, version int) {
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+			http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(make([]byte, 100)))
+		}},
+		{"Last-Modified", func(w http.ResponseWriter, r *http.Request, version int) {
+			http.ServeContent(w, r, "data.parquet", time.Unix(int64(version)*3600, 0), bytes.NewReader(make([]byte, 100)))
+		}},
+		{"ignored preconditions", func(w http.ResponseWriter, r *http.Request, version int) {
+			r.Header.Del("If-Range")
+			r.Header.Del("If-Match")
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+			http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(make([]byte, 100)))
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			version := int32(1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				test.serve(w, r, int(atomic.LoadInt32(&version)))
+			}))
+			defer server.Close()
+
+			pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err = pf.Read(make([]byte, 10)); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			atomic.StoreInt32(&version, 2)
+			var changed *ObjectChangedError
+			if _, err = pf.Read(make([]byte, 10)); !errors.As(err, &changed) {
+				t.Errorf("expected Read to fail with an ObjectChangedError but got %v", err)
+			}
+			if _, err = pf.(io.ReaderAt).ReadAt(make([]byte, 10), 50); !errors.As(err, &changed) {
+				t.Errorf("expected ReadAt to fail with an ObjectChangedError but got %v", err)
+			}
+			if _, err = pf.Open(""); !errors.As(err, &changed) {
+				t.Errorf("expected Open to fail with an ObjectChangedError but got %v", err)
+			}
+		})
+	}
+}
+
+func TestRangeResponseChecked(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		serve   func(w http.ResponseWriter)
+		changed bool
+	}{
+		{"validators dropped", func(w http.ResponseWriter) {
+			w.Write(make([]byte, 100))
+		}, true},
+		{"whole file of another size", func(w http.ResponseWriter) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(make([]byte, 50))
+		}, false},
+		{"Content-Range end", func(w http.ResponseWriter) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Range", "bytes 10-29/100")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(make([]byte, 20))
+		}, false},
+		{"Content-Range size", func(w http.ResponseWriter) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Range", "bytes 10-19/200")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(make([]byte, 10))
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var broken int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&broken) == 1 {
+					test.serve(w)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(make([]byte, 100)))
+			}))
+			defer server.Close()
+
+			pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err = pf.Read(make([]byte, 10)); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			atomic.StoreInt32(&broken, 1)
+			_, err = pf.Read(make([]byte, 10))
+			var changed *ObjectChangedError
+			if err == nil || errors.As(err, &changed) != test.changed {
+				t.Errorf("expected Read to fail (ObjectChangedError %v) but got %v", test.changed, err)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var status int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch code := int(atomic.LoadInt32(&status)); {
+		case r.Header.Get("Range") == "bytes=0-0":
+			http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(data))
+		case code == http.StatusOK:
+			// ignore the range
+			w.Write(data)
+		case code == http.StatusPartialContent:
+			// wrong range
+			w.Header().Set("Content-Range", "bytes 0-9/100")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:10])
+		case code == http.StatusRequestedRangeNotSatisfiable:
+			// short body
+			w.Header().Set("Content-Range", "bytes 50-59/100")
+			w.Header().Set("Content-Length", "5")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[50:55])
+		default:
+			http.Error(w, "<html>error</html>", code)
+		}
+	}))
+	defer server.Close()
+
+	pf, err := NewHttpReader(server.URL, true, false, map[string]string{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	ra := pf.(io.ReaderAt)
+
+	for _, code := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		atomic.StoreInt32(&status, int32(code))
+		var statusErr *StatusError
+		if _, err = ra.ReadAt(make([]byte, 10), 50); !errors.As(err, &statusErr) {
+			t.Fatalf("expected a StatusError but got %v", err)
+		}
+		if statusErr.StatusCode() != code || statusErr.NotFound() != (code == http.StatusNotFound) {
+			t.Errorf("expected status %d but got %d", code, statusErr.StatusCode())
+		}
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	b := make([]byte, 10)
+	if _, err = ra.ReadAt(b, 50); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data[50:60]) {
+		t.Errorf("expected data[50:60] from a 200 response but got %v", b)
+	}
+
+	atomic.StoreInt32(&status, http.StatusPartialContent)
+	if _, err = ra.ReadAt(b, 50); err == nil || !strings.Contains(err.Error(), "Content-Range") {
+		t.Errorf("expected a Content-Range mismatch but got %v", err)
+	}
+
+	atomic.StoreInt32(&status, http.StatusRequestedRangeNotSatisfiable)
+	if _, err = ra.ReadAt(b, 50); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a short response but got %v", err)
+	}
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	var statusErr *StatusError
+	if _, err = NewHttpReader(missing.URL, true, false, map[string]string{}); !errors.As(err, &statusErr) || !statusErr.NotFound() {
+		t.Errorf("expected NewHttpReader to fail with a not found StatusError but got %v", err)
+	}
+}
+
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOptions(t *testing.T) {
+	data := make([]byte, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token "+r.Header.Get("Range") || r.Header.Get("X-Static") != "static" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	transport := &countingTransport{}
+	options := HttpReaderOptions{
+		Client:  &http.Client{Transport: transport},
+		Headers: map[string]string{"X-Static": "static"},
+		RequestHook: func(req *http.Request) error {
+			// the signature covers the headers set by the reader
+			req.Header.Set("Authorization", "Bearer token "+req.Header.Get("Range"))
+			return nil
+		},
+	}
+	pf, err := NewHttpReaderWithOptions(server.URL, options)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	opened, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = opened.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n := atomic.LoadInt32(&transport.requests); n != 3 {
+		t.Errorf("expected 3 requests through the client but got %d", n)
+	}
+
+	options.RequestHook = func(*http.Request) error {
+		return errors.New("token expired")
+	}
+	if _, err = NewHttpReaderWithOptions(server.URL, options); err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("expected the hook error but got %v", err)
+	}
+}
+
+func TestDefaultTransportUnchanged(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(make([]byte, 100)))
+	}))
+	defer server.Close()
+
+	before := http.DefaultTransport.(*http.Transport).TLSClientConfig
+	if _, err := NewHttpReader(server.URL, false, true, map[string]string{}); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if http.DefaultTransport.(*http.Transport).TLSClientConfig != before {
+		t.Error("expected http.DefaultTransport to be left untouched")
+	}
+	if _, err := NewHttpReader(server.URL, false, false, map[string]string{}); err == nil {
+		t.Error("expected the self-signed certificate to be rejected")
+	}
+}
+
+type contextKey struct{}
+
+// contextTracer records whether each span is started from a context
+// carrying contextKey
+type contextTracer struct {
+	lock    sync.Mutex
+	spans   int
+	missing []string
+}
+
+func (c *contextTracer) Start(ctx context.Context, name string, _ ...trace.Attribute) (context.Context, trace.Span) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.spans++
+	if ctx.Value(contextKey{}) == nil {
+		c.missing = append(c.missing, name)
+	}
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...trace.Attribute) {}
+
+func (noopSpan) End(error) {}
+
+func TestContext(t *testing.T) {
+	tracer := &contextTracer{}
+	trace.SetTracer(tracer)
+	defer trace.SetTracer(nil)
+
+	upload := newUploadServer(t)
+	ctx := context.WithValue(context.Background(), contextKey{}, true)
+	for _, options := range []HttpWriterOptions{{Context: ctx}, {Context: ctx, ChunkSize: 300}} {
+		pf, err := NewHttpWriter(upload.URL+"/data.parquet", options)
+		if err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		if _, err = pf.Write(sourcetest.Data()); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+		if err = pf.Close(); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+
+	pf, err := NewHttpReaderWithOptions(upload.URL+"/data.parquet", HttpReaderOptions{Context: ctx})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = pf.Read(make([]byte, 20)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if tracer.spans == 0 || len(tracer.missing) > 0 {
+		t.Errorf("expected every span to be started from the context but got %v", tracer.missing)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err = NewHttpReaderWithOptions(upload.URL+"/data.parquet", HttpReaderOptions{Context: canceled}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error to be %v but got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go/source"
+)
+
+// FallbackOptions configure how NewHttpReaderWithFallback reads the files of
+// servers that do not support range requests
+type FallbackOptions struct {
+	// SpoolDir is the directory of the temporary copy of the file,
+	// os.TempDir is used when empty
+	SpoolDir string
+	// InMemory keeps the copy in memory instead of a temporary file
+	InMemory bool
+}
+
+// SpooledHttpReader serves Seek and Read from a local copy of a file read
+// once, a remote file of a server that ignores the Range header or an
+// uploaded multipart part
+type SpooledHttpReader struct {
+	spool  *spool
+	offset int64
+	closed bool
+}
+
+// spool is the local copy shared by the handles returned by Open(""), it is
+// released when the last of them is closed
+type spool struct {
+	size int64
+	data io.ReaderAt
+	file *os.File
+
+	lock sync.Mutex
+	refs int
+}
+
+// newSpooledHttpReader copies body into a temporary file or memory
+func newSpooledHttpReader(body io.Reader, options FallbackOptions) (*SpooledHttpReader, error) {
+	var maxMemory int64
+	if options.InMemory {
+		maxMemory = -1
+	}
+	s, err := newSpool(body, options.SpoolDir, maxMemory, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "newSpool")
+	}
+	return &SpooledHttpReader{spool: s}, nil
+}
+
+// newSpool copies body into memory up to maxMemory bytes, a negative
+// maxMemory has no limit, and into a temporary file created in dir beyond.
+// ErrPartTooLarge is returned when body is longer than maxSize, 0 has no
+// limit.
+func newSpool(body io.Reader, dir string, maxMemory int64, maxSize int64) (*spool, error) {
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	var buf bytes.Buffer
+	head := body
+	if maxMemory >= 0 {
+		head = io.LimitReader(body, maxMemory+1)
+	}
+	n, err := buf.ReadFrom(head)
+	if err != nil {
+		return nil, errors.Wrap(err, "buf.ReadFrom")
+	}
+	if maxSize > 0 && n > maxSize {
+		return nil, errors.Wrap(ErrPartTooLarge, "ErrPartTooLarge")
+	}
+	if maxMemory < 0 || n <= maxMemory {
+		return &spool{size: n, data: bytes.NewReader(buf.Bytes()), refs: 1}, nil
+	}
+
+	file, err := ioutil.TempFile(dir, "parquet-http-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.TempFile")
+	}
+	size, err := io.Copy(file, io.MultiReader(&buf, body))
+	if err == nil && maxSize > 0 && size > maxSize {
+		err = errors.Wrap(ErrPartTooLarge, "ErrPartTooLarge")
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, errors.Wrap(err, "io.Copy")
+	}
+	return &spool{size: size, data: file, file: file, refs: 1}, nil
+}
+
+func (r *SpooledHttpReader) Create(_ string) (source.ParquetFile, error) {
+	return nil, fmt.Errorf("SpooledHttpReader does not support Create()")
+}
+
+// Open returns a new handle on the same local copy
+func (r *SpooledHttpReader) Open(_ string) (source.ParquetFile, error) {
+	r.spool.lock.Lock()
+	defer r.spool.lock.Unlock()
+	if r.spool.refs == 0 {
+		return nil, errors.New("SpooledHttpReader is closed")
+	}
+	r.spool.refs++
+	return &SpooledHttpReader{spool: r.spool}, nil
+}
+
+func (r *SpooledHttpReader) Seek(offset int64, pos int) (int64, error) {
+	switch pos {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.spool.size
+	default:
+		return 0, fmt.Errorf("unknown whence: %d", pos)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("invalid offset: %d", offset)
+	}
+	if offset > r.spool.size {
+		offset = r.spool.size
+	}
+	r.offset = offset
+	return r.offset, nil
+}
+
+// Read reads from the local copy, io.EOF is returned when b could not be
+// filled
+func (r *SpooledHttpReader) Read(b []byte) (int, error) {
+	n, err := r.ReadAt(b, r.offset)
+	r.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes starting at off without moving the offset used
+// by Read
+func (r *SpooledHttpReader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset: %d", off)
+	}
+	if off >= r.spool.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	n, err := r.spool.data.ReadAt(b, off)
+	if err != nil {
+		return n, errors.Wrap(err, "r.spool.data.ReadAt")
+	}
+	return n, nil
+}
+
+// Size returns the size in bytes of the remote file
+func (r *SpooledHttpReader) Size() int64 {
+	return r.spool.size
+}
+
+func (r *SpooledHttpReader) Write(_ []byte) (int, error) {
+	return 0, errors.New("SpooledHttpReader does not support Write()")
+}
+
+// Close releases the handle, the local copy is removed once every handle is
+// closed
+func (r *SpooledHttpReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	r.spool.lock.Lock()
+	defer r.spool.lock.Unlock()
+	r.spool.refs--
+	if r.spool.refs > 0 || r.spool.file == nil {
+		return nil
+	}
+	if err := r.spool.file.Close(); err != nil {
+		return errors.Wrap(err, "r.spool.file.Close")
+	}
+	if err := os.Remove(r.spool.file.Name()); err != nil {
+		return errors.Wrap(err, "os.Remove")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+// noRangeServer serves data with a 200 to every request, ignoring Range
+func noRangeServer(t *testing.T, data []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSpooledConformance(t *testing.T) {
+	for name, options := range map[string]FallbackOptions{
+		"memory": {InMemory: true},
+		"file":   {SpoolDir: t.TempDir()},
+	} {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			sourcetest.Run(t, sourcetest.Harness{
+				NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+					pf, err := NewHttpReaderWithFallback(noRangeServer(t, data).URL, true, false, map[string]string{}, options)
+					if err != nil {
+						t.Fatalf("expected error to be nil but got %q", err.Error())
+					}
+					if _, ok := pf.(*SpooledHttpReader); !ok {
+						t.Fatalf("expected a SpooledHttpReader but got %T", pf)
+					}
+					return pf
+				},
+			})
+		})
+	}
+}
+
+func TestSpoolRemoved(t *testing.T) {
+	dir := t.TempDir()
+	pf, err := NewHttpReaderWithFallback(noRangeServer(t, sourcetest.Data()).URL, true, false, map[string]string{}, FallbackOptions{SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	opened, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected the spool to be kept while a handle is open but got %d files", len(files))
+	}
+	if err := opened.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected the spool to be removed but got %d files", len(files))
+	}
+}
+
+func TestHeadFallback(t *testing.T) {
+	data := sourcetest.Data()
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") == "bytes=0-0" {
+			atomic.AddInt32(&probes, 1)
+		}
+		http.ServeContent(w, r, "data.parquet", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	pf, err := NewHttpReaderWithFallback(server.URL, true, false, map[string]string{}, FallbackOptions{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	reader, ok := pf.(*HttpReader)
+	if !ok {
+		t.Fatalf("expected an HttpReader but got %T", pf)
+	}
+	if reader.Size() != int64(len(data)) {
+		t.Errorf("expected size %d but got %d", len(data), reader.Size())
+	}
+	if n := atomic.LoadInt32(&probes); n != 0 {
+		t.Errorf("expected the size to be read with HEAD but got %d range probes", n)
+	}
+
+	b := make([]byte, 10)
+	if _, err := reader.ReadAt(b, 100); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data[100:110]) {
+		t.Errorf("expected data[100:110] but got %v", b)
+	}
+}
+
+func TestNoFallback(t *testing.T) {
+	if _, err := NewHttpReader(noRangeServer(t, sourcetest.Data()).URL, true, false, map[string]string{}); err == nil {
+		t.Error("expected NewHttpReader to reject a server without range support")
+	}
+}
@@ -0,0 +1,434 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
+	"github.com/sabey/parquet-go/source"
+)
+
+// DefaultMaxChunkAttempts is the number of times a chunk is sent when
+// HttpWriterOptions.MaxChunkAttempts is unset
+const DefaultMaxChunkAttempts = 3
+
+// statusResumeIncomplete is sent by resumable upload servers once a chunk
+// that is not the last one has been stored
+const statusResumeIncomplete = 308
+
+// ErrAborted is returned by Write and Close after Abort
+var ErrAborted = errors.New("HttpWriter: aborted")
+
+// HttpWriterOptions configure NewHttpWriter
+type HttpWriterOptions struct {
+	// Method is http.MethodPut or http.MethodPost, PUT is used when empty
+	Method string
+	// Context is the context of every request and the parent of their
+	// trace spans, context.Background() is used when nil
+	Context context.Context
+	// Client sends every request, http.DefaultClient is used when nil
+	Client *http.Client
+	// Headers are added to every request
+	Headers map[string]string
+	// RequestHook is called on every request right before it is sent
+	RequestHook func(req *http.Request) error
+	// ChunkSize enables resumable uploads: the file is sent in requests of
+	// ChunkSize bytes carrying a Content-Range header, a failed chunk is
+	// sent again without restarting the upload. When 0 the file is streamed
+	// in a single chunked request.
+	ChunkSize int
+	// MaxChunkAttempts is the number of times a chunk is sent before the
+	// upload fails
+	MaxChunkAttempts int
+}
+
+// HttpWriter uploads a parquet file to a URL while it is written
+type HttpWriter struct {
+	url     string
+	options HttpWriterOptions
+
+	// streamed upload
+	pipe *io.PipeWriter
+	done chan error
+
+	// resumable upload
+	buf    []byte
+	offset int64
+	part   int64
+
+	err    error
+	closed bool
+}
+
+// NewHttpWriter prepares an upload to uri. The first request is sent by
+// the first Write, or by Close for an empty file, and the upload completes
+// when the writer is closed.
+func NewHttpWriter(uri string, options HttpWriterOptions) (source.ParquetFile, error) {
+	if options.Method == "" {
+		options.Method = http.MethodPut
+	}
+	if options.Method != http.MethodPut && options.Method != http.MethodPost {
+		return nil, fmt.Errorf("HttpWriter does not support method %s", options.Method)
+	}
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.MaxChunkAttempts <= 0 {
+		options.MaxChunkAttempts = DefaultMaxChunkAttempts
+	}
+
+	w := &HttpWriter{
+		url:     uri,
+		options: options,
+	}
+	if options.ChunkSize > 0 {
+		w.buf = make([]byte, 0, options.ChunkSize)
+	}
+	return w, nil
+}
+
+// stream starts a single request whose body is fed by Write, so that a
+// writer dropped before its first Write holds no request
+func (w *HttpWriter) stream() error {
+	pr, pw := io.Pipe()
+	req, err := w.newRequest(pr, nil)
+	if err != nil {
+		return errors.Wrap(err, "w.newRequest")
+	}
+	ctx, span := trace.Start(req.Context(), "http."+w.options.Method, urlAttributes(w.url, "")...)
+	req = req.WithContext(ctx)
+
+	w.pipe = pw
+	w.done = make(chan error, 1)
+	go func() {
+		resp, err := w.options.Client.Do(req)
+		if err == nil {
+			span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = newStatusError(w.url, resp)
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		span.End(err)
+		// unblock Write when the server answered before reading the body
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			pr.Close()
+		}
+		w.done <- err
+	}()
+	return nil
+}
+
+// newRequest returns an upload request of body with the configured headers,
+// header and whatever RequestHook adds
+func (w *HttpWriter) newRequest(body io.Reader, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(w.options.Context, w.options.Method, w.url, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequestWithContext")
+	}
+	for k, v := range w.options.Headers {
+		req.Header.Add(k, v)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.options.RequestHook != nil {
+		if err := w.options.RequestHook(req); err != nil {
+			return nil, errors.Wrap(err, "w.options.RequestHook")
+		}
+	}
+	return req, nil
+}
+
+// Write sends p to the server, in resumable mode it is buffered until a
+// chunk is complete
+func (w *HttpWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("HttpWriter is closed")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if w.options.ChunkSize == 0 {
+		if w.pipe == nil {
+			if err := w.stream(); err != nil {
+				w.err = errors.Wrap(err, "w.stream")
+				return 0, w.err
+			}
+		}
+		n, err := w.pipe.Write(p)
+		if err != nil {
+			w.err = errors.Wrap(err, "w.pipe.Write")
+			return n, w.err
+		}
+		return n, nil
+	}
+
+	var written int
+	for len(p) > 0 {
+		n := w.options.ChunkSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == w.options.ChunkSize {
+			if err := w.sendChunk(false); err != nil {
+				w.err = errors.Wrap(err, "w.sendChunk")
+				return written, w.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// sendChunk sends the buffered bytes with a Content-Range header, the total
+// size is only announced with the last chunk. Before sending a chunk again
+// the server is asked how much of it was stored, only the rest is resent.
+func (w *HttpWriter) sendChunk(last bool) error {
+	var sent int
+	var err error
+	for attempt := 1; attempt <= w.options.MaxChunkAttempts; attempt++ {
+		if attempt > 1 {
+			var committed int64
+			var complete bool
+			committed, complete, err = w.committed()
+			if err != nil {
+				if retryableChunkError(err) {
+					continue
+				}
+				break
+			}
+			end := w.offset + int64(len(w.buf))
+			if committed < w.offset || committed > end || (complete && !last) {
+				err = fmt.Errorf("server committed %d bytes of the upload, expected %d to %d", committed, w.offset, end)
+				break
+			}
+			if complete {
+				break
+			}
+			sent = int(committed - w.offset)
+		}
+		err = w.putChunk(sent, last)
+		if err == nil || !retryableChunkError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	w.offset += int64(len(w.buf))
+	w.part++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// putChunk sends the buffered bytes from offset from on
+func (w *HttpWriter) putChunk(from int, last bool) error {
+	body := w.buf[from:]
+	start := w.offset + int64(from)
+	total := "*"
+	if last {
+		total = strconv.FormatInt(w.offset+int64(len(w.buf)), 10)
+	}
+	contentRange := fmt.Sprintf("bytes */%s", total)
+	if len(body) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", start, start+int64(len(body))-1, total)
+	}
+
+	req, err := w.newRequest(bytes.NewReader(body), http.Header{contentRangeHeader: {contentRange}})
+	if err != nil {
+		return errors.Wrap(err, "w.newRequest")
+	}
+	ctx, span := trace.Start(req.Context(), "http."+w.options.Method, urlAttributes(w.url, contentRange)...)
+	span.SetAttributes(trace.Int64(trace.AttrPart, w.part+1), trace.Int64(trace.AttrBytes, int64(len(body))))
+	resp, err := w.options.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.End(err)
+		return errors.Wrap(err, "w.options.Client.Do")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
+
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && (last || resp.StatusCode != statusResumeIncomplete) {
+		err = newStatusError(w.url, resp)
+	}
+	span.End(err)
+	return err
+}
+
+// committed asks the server how many bytes of the upload it stored with an
+// empty request of Content-Range bytes */*. The server answers 308 with the
+// stored bytes in its Range header, or 2xx when the upload is complete.
+func (w *HttpWriter) committed() (int64, bool, error) {
+	const contentRange = "bytes */*"
+	req, err := w.newRequest(http.NoBody, http.Header{contentRangeHeader: {contentRange}})
+	if err != nil {
+		return 0, false, errors.Wrap(err, "w.newRequest")
+	}
+	ctx, span := trace.Start(req.Context(), "http."+w.options.Method, urlAttributes(w.url, contentRange)...)
+	resp, err := w.options.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.End(err)
+		return 0, false, errors.Wrap(err, "w.options.Client.Do")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	span.SetAttributes(trace.Int64(trace.AttrStatusCode, int64(resp.StatusCode)))
+
+	var committed int64
+	var complete bool
+	switch {
+	case resp.StatusCode == statusResumeIncomplete:
+		// no Range header means nothing was stored
+		if v := resp.Header.Get("Range"); v != "" {
+			var last int64
+			if _, err = fmt.Sscanf(v, "bytes=0-%d", &last); err != nil {
+				err = errors.Wrapf(err, "invalid Range header %q", v)
+			}
+			committed = last + 1
+		}
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		committed, complete = w.offset+int64(len(w.buf)), true
+	default:
+		err = newStatusError(w.url, resp)
+	}
+	span.End(err)
+	return committed, complete, err
+}
+
+// retryableChunkError reports whether sending a chunk again may succeed
+func retryableChunkError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode()
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Abort stops the upload: the body of a streamed upload ends with
+// ErrAborted, which cancels its request, and a resumable upload sends no
+// more chunks. It blocks until the streamed request returns and has no
+// effect after Close.
+func (w *HttpWriter) Abort() {
+	if w.closed || w.err == ErrAborted {
+		return
+	}
+	w.err = ErrAborted
+	w.buf = nil
+	if w.pipe != nil {
+		w.pipe.CloseWithError(ErrAborted)
+		<-w.done
+	}
+}
+
+// Close completes the upload and returns the error of the server, if any
+func (w *HttpWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.err == ErrAborted {
+		return ErrAborted
+	}
+
+	if w.options.ChunkSize == 0 {
+		if w.pipe == nil {
+			if w.err != nil {
+				return w.err
+			}
+			if err := w.stream(); err != nil {
+				return errors.Wrap(err, "w.stream")
+			}
+		}
+		w.pipe.Close()
+		if err := <-w.done; err != nil {
+			return errors.Wrap(err, "upload")
+		}
+		return nil
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.sendChunk(true); err != nil {
+		return errors.Wrap(err, "w.sendChunk")
+	}
+	return nil
+}
+
+// Create prepares an upload to name, resolved against the URL of w
+func (w *HttpWriter) Create(name string) (source.ParquetFile, error) {
+	uri, err := w.resolve(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "w.resolve")
+	}
+	pf, err := NewHttpWriter(uri, w.options)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewHttpWriter")
+	}
+	return pf, nil
+}
+
+// Open returns an HttpReader of name, resolved against the URL of w, using
+// the same context, client, headers and hook
+func (w *HttpWriter) Open(name string) (source.ParquetFile, error) {
+	uri, err := w.resolve(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "w.resolve")
+	}
+	pf, err := NewHttpReaderWithOptions(uri, HttpReaderOptions{
+		Context:     w.options.Context,
+		Client:      w.options.Client,
+		Headers:     w.options.Headers,
+		RequestHook: w.options.RequestHook,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "NewHttpReaderWithOptions")
+	}
+	return pf, nil
+}
+
+func (w *HttpWriter) resolve(name string) (string, error) {
+	if name == "" {
+		return w.url, nil
+	}
+	base, err := url.Parse(w.url)
+	if err != nil {
+		return "", errors.Wrap(err, "url.Parse")
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return "", errors.Wrap(err, "url.Parse")
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func (w *HttpWriter) Seek(_ int64, _ int) (int64, error) {
+	return 0, errors.New("HttpWriter does not support Seek()")
+}
+
+func (w *HttpWriter) Read(_ []byte) (int, error) {
+	return 0, errors.New("HttpWriter does not support Read()")
+}
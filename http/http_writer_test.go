@@ -0,0 +1,289 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+// uploadServer stores the files uploaded with PUT or POST, appending the
+// chunks sent with a Content-Range header, and serves them with GET. The
+// chunks stored so far are reported in the Range header of its 308s.
+type uploadServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	files    map[string][]byte
+	failures int32
+	// truncated chunks have only their first half stored before a 503
+	truncated int32
+	chunked   int32
+}
+
+func newUploadServer(t *testing.T) *uploadServer {
+	s := &uploadServer{files: map[string][]byte{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *uploadServer) file(path string) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.files[path]
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// streamed uploads are read before locking, their writer may be waiting
+	// for another request to complete
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		data, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+		return
+	}
+
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	contentRange := r.Header.Get("Content-Range")
+	if contentRange == "" {
+		if len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked" {
+			atomic.AddInt32(&s.chunked, 1)
+		}
+		s.files[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		start = int64(len(s.files[r.URL.Path]))
+		if _, err := fmt.Sscanf(contentRange, "bytes */%s", &total); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if start == 0 {
+		s.files[r.URL.Path] = nil
+	}
+	if start != int64(len(s.files[r.URL.Path])) {
+		http.Error(w, "unexpected offset", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if len(body) > 1 && atomic.AddInt32(&s.truncated, -1) >= 0 {
+		s.files[r.URL.Path] = append(s.files[r.URL.Path], body[:len(body)/2]...)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.files[r.URL.Path] = append(s.files[r.URL.Path], body...)
+	if total == "*" {
+		if n := len(s.files[r.URL.Path]); n > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+		}
+		w.WriteHeader(statusResumeIncomplete)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestHttpWriterConformance(t *testing.T) {
+	for name, options := range map[string]HttpWriterOptions{
+		"streamed":  {},
+		"post":      {Method: http.MethodPost},
+		"resumable": {ChunkSize: 300},
+	} {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			server := newUploadServer(t)
+			var names int32
+			newName := func(t *testing.T) string {
+				return fmt.Sprintf("file-%d.parquet", atomic.AddInt32(&names, 1))
+			}
+			sourcetest.Run(t, sourcetest.Harness{
+				NewWriter: func(t *testing.T) source.ParquetFile {
+					pf, err := NewHttpWriter(server.URL+"/dir/"+newName(t), options)
+					if err != nil {
+						t.Fatalf("expected error to be nil but got %q", err.Error())
+					}
+					return pf
+				},
+				NewName: newName,
+			})
+		})
+	}
+}
+
+func TestHttpWriterStreamed(t *testing.T) {
+	server := newUploadServer(t)
+	pf, err := NewHttpWriter(server.URL+"/data.parquet", HttpWriterOptions{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := pf.Write(sourcetest.Data()); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n := len(server.file("/data.parquet")); n != 10*len(sourcetest.Data()) {
+		t.Errorf("expected %d bytes to be uploaded but got %d", 10*len(sourcetest.Data()), n)
+	}
+	if n := atomic.LoadInt32(&server.chunked); n != 1 {
+		t.Errorf("expected 1 chunked request but got %d", n)
+	}
+}
+
+func TestHttpWriterStreamedStartsOnWrite(t *testing.T) {
+	server := newUploadServer(t)
+
+	// a writer dropped before its first Write holds no request
+	pf, err := NewHttpWriter(server.URL+"/dropped.parquet", HttpWriterOptions{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if w := pf.(*HttpWriter); w.pipe != nil || w.done != nil {
+		t.Errorf("expected no request before the first Write")
+	}
+
+	// Close sends an empty file
+	pf, err = NewHttpWriter(server.URL+"/empty.parquet", HttpWriterOptions{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.lock.Lock()
+	data, ok := server.files["/empty.parquet"]
+	server.lock.Unlock()
+	if !ok || len(data) != 0 {
+		t.Errorf("expected an empty file to be uploaded but got %d bytes (%v)", len(data), ok)
+	}
+}
+
+func TestHttpWriterResume(t *testing.T) {
+	server := newUploadServer(t)
+	atomic.StoreInt32(&server.failures, 2)
+
+	data := sourcetest.Data()
+	pf, err := NewHttpWriter(server.URL+"/data.parquet", HttpWriterOptions{ChunkSize: 256})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := pf.Write(data); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(server.file("/data.parquet"), data) {
+		t.Errorf("expected the uploaded file to match the data written")
+	}
+
+	atomic.StoreInt32(&server.failures, DefaultMaxChunkAttempts)
+	pf, err = NewHttpWriter(server.URL+"/failed.parquet", HttpWriterOptions{ChunkSize: 256})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := pf.Write(data); err == nil {
+		t.Error("expected Write to fail once every attempt failed")
+	}
+	if err := pf.Close(); err == nil {
+		t.Error("expected Close to return the upload error")
+	}
+}
+
+func TestHttpWriterResumeCommitted(t *testing.T) {
+	server := newUploadServer(t)
+	data := sourcetest.Data()
+	pf, err := NewHttpWriter(server.URL+"/data.parquet", HttpWriterOptions{ChunkSize: 256})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err := pf.Write(data[:256]); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	// resending the whole second chunk would overlap the stored half
+	atomic.StoreInt32(&server.truncated, 1)
+	if _, err := pf.Write(data[256:]); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(server.file("/data.parquet"), data) {
+		t.Errorf("expected the uploaded file to match the data written")
+	}
+}
+
+func TestHttpWriterAbort(t *testing.T) {
+	for name, options := range map[string]HttpWriterOptions{
+		"streamed":  {},
+		"resumable": {ChunkSize: 256},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := newUploadServer(t)
+			pf, err := NewHttpWriter(server.URL+"/aborted.parquet", options)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err := pf.Write(sourcetest.Data()); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			pf.(*HttpWriter).Abort()
+			if _, err := pf.Write(sourcetest.Data()); err != ErrAborted {
+				t.Errorf("expected Write to return ErrAborted but got %v", err)
+			}
+			if err := pf.Close(); err != ErrAborted {
+				t.Errorf("expected Close to return ErrAborted but got %v", err)
+			}
+			if n := len(server.file("/aborted.parquet")); n >= len(sourcetest.Data()) {
+				t.Errorf("expected the upload to be incomplete but %d bytes were stored", n)
+			}
+		})
+	}
+}
+
+func TestHttpWriterStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	pf, err := NewHttpWriter(server.URL, HttpWriterOptions{})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pf.Write(sourcetest.Data())
+	var statusErr *StatusError
+	if err := pf.Close(); !errors.As(err, &statusErr) || statusErr.StatusCode() != http.StatusForbidden {
+		t.Errorf("expected a 403 StatusError but got %v", err)
+	}
+}
@@ -0,0 +1,379 @@
+// Package checksum computes the checksums of the objects written by the S3
+// sources and verifies the stored checksum of the objects they read.
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMismatch is returned when the bytes read do not match the checksum
+	// of the object
+	ErrMismatch = errors.New("Read: checksum mismatch")
+	// ErrUnavailable is returned when an object has no checksum its bytes
+	// can be verified against
+	ErrUnavailable = errors.New("Open: no checksum to verify")
+)
+
+// Algorithms of the checksums sent with uploads, as in the
+// x-amz-checksum-algorithm header
+const (
+	CRC32C = "CRC32C"
+	SHA256 = "SHA256"
+)
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Valid reports whether algorithm is CRC32C or SHA256
+func Valid(algorithm string) bool {
+	return algorithm == CRC32C || algorithm == SHA256
+}
+
+// Header returns the header carrying the checksum of a body computed with
+// algorithm, such as X-Amz-Checksum-Crc32c
+func Header(algorithm string) string {
+	return http.CanonicalHeaderKey("x-amz-checksum-" + strings.ToLower(algorithm))
+}
+
+// Sums are the base64 encoded checksums of a body
+type Sums struct {
+	MD5    string
+	CRC32C string
+	SHA256 string
+}
+
+// Get returns the checksum of algorithm
+func (s Sums) Get(algorithm string) string {
+	switch algorithm {
+	case CRC32C:
+		return s.CRC32C
+	case SHA256:
+		return s.SHA256
+	}
+	return ""
+}
+
+type part struct {
+	md5    []byte
+	crc32c []byte
+	sha256 []byte
+}
+
+func (p part) sums() Sums {
+	return Sums{
+		MD5:    base64.StdEncoding.EncodeToString(p.md5),
+		CRC32C: base64.StdEncoding.EncodeToString(p.crc32c),
+		SHA256: base64.StdEncoding.EncodeToString(p.sha256),
+	}
+}
+
+// Parts records the checksums of the bodies of an upload to compute the
+// ones S3 gives the object. It is safe for concurrent use.
+type Parts struct {
+	lock      sync.Mutex
+	parts     map[int64]part
+	multipart bool
+}
+
+// Add computes the checksums of the body of part number, 0 for a
+// PutObject. body is rewound.
+func (p *Parts) Add(number int64, body io.ReadSeeker) (Sums, error) {
+	md5Hash, crc32cHash, sha256Hash := md5.New(), crc32.New(crc32cTable), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, crc32cHash, sha256Hash), body); err != nil {
+		return Sums{}, errors.Wrap(err, "io.Copy")
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return Sums{}, errors.Wrap(err, "body.Seek")
+	}
+	sums := part{
+		md5:    md5Hash.Sum(nil),
+		crc32c: crc32cHash.Sum(nil),
+		sha256: sha256Hash.Sum(nil),
+	}
+
+	p.lock.Lock()
+	if p.parts == nil {
+		p.parts = map[int64]part{}
+	}
+	// a retried part replaces the previous attempt
+	p.parts[number] = sums
+	p.multipart = p.multipart || number > 0
+	p.lock.Unlock()
+	return sums.sums(), nil
+}
+
+// ordered returns the parts sorted by number, p.lock must be held
+func (p *Parts) ordered() []part {
+	numbers := make([]int64, 0, len(p.parts))
+	for number := range p.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	parts := make([]part, len(numbers))
+	for i, number := range numbers {
+		parts[i] = p.parts[number]
+	}
+	return parts
+}
+
+// ETag returns the quoted ETag of the object, the MD5 of the body of a
+// PutObject or the MD5 of the MD5 of every part followed by the number of
+// parts. It is empty when nothing was uploaded.
+func (p *Parts) ETag() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	parts := p.ordered()
+	if len(parts) == 0 {
+		return ""
+	}
+	if !p.multipart {
+		return `"` + hex.EncodeToString(parts[0].md5) + `"`
+	}
+
+	var sums []byte
+	for _, part := range parts {
+		sums = append(sums, part.md5...)
+	}
+	return multipartETag(sums, len(parts))
+}
+
+// CRC32C returns the CRC32C S3 gives the object when it is sent with every
+// body, see composite
+func (p *Parts) CRC32C() string {
+	return p.composite(crc32.New(crc32cTable), func(part part) []byte { return part.crc32c })
+}
+
+// SHA256 returns the SHA-256 S3 gives the object when it is sent with every
+// body, see composite
+func (p *Parts) SHA256() string {
+	return p.composite(sha256.New(), func(part part) []byte { return part.sha256 })
+}
+
+// composite returns the base64 encoded checksum of the body of a PutObject,
+// or the checksum of the checksums of every part followed by the number of
+// parts. It is empty when nothing was uploaded.
+func (p *Parts) composite(h hash.Hash, sum func(part) []byte) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	parts := p.ordered()
+	if len(parts) == 0 {
+		return ""
+	}
+	if !p.multipart {
+		return base64.StdEncoding.EncodeToString(sum(parts[0]))
+	}
+
+	for _, part := range parts {
+		h.Write(sum(part))
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+// CompletedPart is a part listed by a CompleteMultipartUpload request
+type CompletedPart struct {
+	Number int64
+	ETag   string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+	ETag           string `xml:"ETag"`
+	PartNumber     int64  `xml:"PartNumber"`
+}
+
+// CompleteMultipartUpload returns the body of a CompleteMultipartUpload
+// request listing parts with their checksum of algorithm, which S3 requires
+// when the upload was created with that algorithm. The SDKs do not send it.
+func (p *Parts) CompleteMultipartUpload(algorithm string, parts []CompletedPart) ([]byte, error) {
+	body := completeMultipartUpload{Xmlns: xmlns}
+	p.lock.Lock()
+	for _, cp := range parts {
+		part, ok := p.parts[cp.Number]
+		if !ok {
+			p.lock.Unlock()
+			return nil, fmt.Errorf("part %d was not uploaded", cp.Number)
+		}
+		completed := completedPart{ETag: cp.ETag, PartNumber: cp.Number}
+		switch algorithm {
+		case CRC32C:
+			completed.ChecksumCRC32C = part.sums().CRC32C
+		case SHA256:
+			completed.ChecksumSHA256 = part.sums().SHA256
+		}
+		body.Parts = append(body.Parts, completed)
+	}
+	p.lock.Unlock()
+
+	b, err := xml.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	return b, nil
+}
+
+func multipartETag(sums []byte, parts int) string {
+	sum := md5.Sum(sums)
+	return fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), parts)
+}
+
+var etagPattern = regexp.MustCompile(`^"?[0-9a-f]{32}(-[0-9]+)?"?$`)
+
+// MD5 is the algorithm of a Sum holding an ETag computed from the MD5 of
+// the object
+const MD5 = "MD5"
+
+// Sum is the checksum of an object a scan is verified against
+type Sum struct {
+	// Algorithm is CRC32C, SHA256 or MD5
+	Algorithm string
+	// Value is base64 encoded as in the x-amz-checksum headers, or the ETag
+	// for MD5
+	Value string
+}
+
+// Multipart reports whether s is the checksum of a multipart upload, the
+// checksum of the checksums of its parts followed by their number
+func (s Sum) Multipart() bool {
+	return strings.Contains(s.Value, "-")
+}
+
+// Stored returns the checksum S3 stores for an object, from the
+// x-amz-checksum headers of a HeadObject sent with x-amz-checksum-mode:
+// ENABLED. Without one, the ETag is used when it is the MD5 of the object,
+// which is not the case of objects encrypted with KMS or a customer key.
+// ErrUnavailable is returned when the object has neither.
+func Stored(header http.Header, etag string, encrypted bool) (Sum, error) {
+	for _, algorithm := range []string{CRC32C, SHA256} {
+		if value := header.Get(Header(algorithm)); value != "" {
+			return Sum{Algorithm: algorithm, Value: value}, nil
+		}
+	}
+	if !encrypted && etagPattern.MatchString(etag) {
+		return Sum{Algorithm: MD5, Value: etag}, nil
+	}
+	return Sum{}, ErrUnavailable
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case CRC32C:
+		return crc32.New(crc32cTable)
+	case SHA256:
+		return sha256.New()
+	}
+	return md5.New()
+}
+
+// Verifier computes the checksum of an object read sequentially from its
+// start and compares it with the expected one once the last byte was read
+type Verifier struct {
+	expected Sum
+	size     int64
+	partSize int64
+
+	offset int64
+	part   hash.Hash
+	sums   []byte
+	parts  int
+	broken bool
+}
+
+// NewVerifier returns a Verifier for an object of size bytes, partSize is
+// the size of its parts when expected is the checksum of a multipart upload
+func NewVerifier(expected Sum, size int64, partSize int64) *Verifier {
+	return &Verifier{
+		expected: expected,
+		size:     size,
+		partSize: partSize,
+		part:     newHash(expected.Algorithm),
+	}
+}
+
+// Write records p, read at off. Verification stops at the first read that
+// does not follow the previous one, nil is returned until the last byte was
+// read, then ErrMismatch if the checksum differs.
+func (v *Verifier) Write(off int64, p []byte) error {
+	if v.broken || off != v.offset || len(p) == 0 {
+		v.broken = v.broken || off != v.offset
+		return nil
+	}
+
+	for len(p) > 0 {
+		n := int64(len(p))
+		if v.partSize > 0 {
+			if left := v.partSize - v.offset%v.partSize; n > left {
+				n = left
+			}
+		}
+		v.part.Write(p[:n])
+		v.offset += n
+		p = p[n:]
+		if v.partSize > 0 && (v.offset%v.partSize == 0 || v.offset == v.size) {
+			v.sums = append(v.sums, v.part.Sum(nil)...)
+			v.parts++
+			v.part.Reset()
+		}
+	}
+
+	if v.offset < v.size {
+		return nil
+	}
+	v.broken = true // verified once
+
+	got, expected := v.sum(), v.expected.Value
+	if v.expected.Algorithm == MD5 {
+		expected = `"` + trimQuotes(expected) + `"`
+	}
+	if got != expected {
+		return errors.Wrapf(ErrMismatch, "expected %s %s but got %s", v.expected.Algorithm, v.expected.Value, got)
+	}
+	return nil
+}
+
+// sum returns the checksum of the bytes read, in the format of v.expected
+func (v *Verifier) sum() string {
+	if v.expected.Algorithm == MD5 {
+		if v.partSize > 0 {
+			return multipartETag(v.sums, v.parts)
+		}
+		return `"` + hex.EncodeToString(v.part.Sum(nil)) + `"`
+	}
+
+	if v.partSize > 0 {
+		h := newHash(v.expected.Algorithm)
+		h.Write(v.sums)
+		return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), v.parts)
+	}
+	return base64.StdEncoding.EncodeToString(v.part.Sum(nil))
+}
+
+func trimQuotes(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
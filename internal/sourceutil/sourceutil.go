@@ -0,0 +1,99 @@
+// Package sourceutil holds helpers shared by the ParquetFile wrappers.
+package sourceutil
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go/source"
+)
+
+// Sizer is implemented by sources that know the size of the object they read
+type Sizer interface {
+	Size() int64
+}
+
+// Size returns the size of the file behind pf. Sources that do not implement
+// Sizer are measured with Seek, and their offset is restored afterwards.
+func Size(pf source.ParquetFile) (int64, error) {
+	if s, ok := pf.(Sizer); ok {
+		return s.Size(), nil
+	}
+
+	current, err := pf.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, errors.Wrap(err, "pf.Seek")
+	}
+	size, err := pf.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, errors.Wrap(err, "pf.Seek")
+	}
+	if _, err = pf.Seek(current, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "pf.Seek")
+	}
+	return size, nil
+}
+
+// ReadAt reads len(p) bytes starting at off. It returns io.EOF (possibly
+// wrapped) when fewer than len(p) bytes are available. Sources implementing
+// io.ReaderAt are read without moving their offset.
+func ReadAt(pf source.ParquetFile, p []byte, off int64) (int, error) {
+	if ra, ok := pf.(io.ReaderAt); ok {
+		n, err := ra.ReadAt(p, off)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, err
+			}
+			return n, errors.Wrap(err, "ra.ReadAt")
+		}
+		return n, nil
+	}
+
+	if _, err := pf.Seek(off, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "pf.Seek")
+	}
+
+	var cnt int
+	for cnt < len(p) {
+		n, err := pf.Read(p[cnt:])
+		cnt += n
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return cnt, err
+			}
+			return cnt, errors.Wrap(err, "pf.Read")
+		}
+		if n == 0 {
+			return cnt, errors.Wrap(io.ErrNoProgress, "pf.Read")
+		}
+	}
+	return cnt, nil
+}
+
+// Detach returns a context holding the values of ctx that is never
+// canceled, for the requests cleaning up after ctx was canceled
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -79,6 +79,16 @@ func (self *LocalFile) Read(b []byte) (cnt int, err error) {
 	return cnt, nil
 }
 
+// ReadAt reads len(p) bytes starting at off without moving the offset used
+// by Read, it is safe for concurrent use
+func (self *LocalFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := self.File.ReadAt(b, off)
+	if err != nil {
+		return n, errors.Wrap(err, "self.File.ReadAt")
+	}
+	return n, nil
+}
+
 func (self *LocalFile) Write(b []byte) (n int, err error) {
 	n, err = self.File.Write(b)
 	if err != nil {
@@ -88,7 +98,7 @@ func (self *LocalFile) Write(b []byte) (n int, err error) {
 }
 
 func (self *LocalFile) Close() error {
-	if err := self.File.Close(); err != nil {
+	if err := self.File.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
 		return errors.Wrap(err, "self.File.Close")
 	}
 	return nil
@@ -0,0 +1,32 @@
+package local
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+func TestConformance(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		files int
+	)
+	newName := func(t *testing.T) string {
+		files++
+		return filepath.Join(dir, fmt.Sprintf("%d.parquet", files))
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewLocalFileWriter(newName(t))
+			if err != nil {
+				t.Fatalf("NewLocalFileWriter: %v", err)
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
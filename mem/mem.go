@@ -12,6 +12,11 @@ import (
 // desclare unexported in-memory file-system
 var memFs afero.Fs
 
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+)
+
 // SetInMemFileFs - overrides local in-memory fileSystem
 // NOTE: this is set by NewMemFileWriter is created
 // and memFs is still nil
@@ -35,6 +40,8 @@ type MemFile struct {
 	FilePath string
 	File     afero.File
 	OnClose  OnCloseFunc
+
+	closed bool
 }
 
 // NewMemFileWriter - intiates and creates an instance of MemFiles
@@ -56,38 +63,63 @@ func NewMemFileWriter(name string, f OnCloseFunc) (source.ParquetFile, error) {
 	return pf, nil
 }
 
-// Create - create in-memory file
+// Create - create in-memory file, the returned MemFile
+// inherits the OnCloseFunc
 func (fs *MemFile) Create(name string) (source.ParquetFile, error) {
 	file, err := memFs.Create(name)
 	if err != nil {
 		return fs, errors.Wrap(err, "memFs.Create")
 	}
 
-	fs.File = file
-	fs.FilePath = name
-	return fs, nil
+	return &MemFile{
+		FilePath: name,
+		File:     file,
+		OnClose:  fs.OnClose,
+	}, nil
 }
 
-// Open - open file in-memory
+// Open - open file in-memory, every call returns a
+// new reader with its own offset
 func (fs *MemFile) Open(name string) (source.ParquetFile, error) {
-	var (
-		err error
-	)
 	if name == "" {
 		name = fs.FilePath
 	}
 
-	fs.FilePath = name
-	fs.File, err = memFs.Open(name)
+	file, err := memFs.Open(name)
 	if err != nil {
 		return fs, errors.Wrap(err, "memFs.Open")
 	}
-	return fs, nil
+	return &MemFile{
+		FilePath: name,
+		File:     file,
+	}, nil
 }
 
-// Seek - seek function
+// Seek - seek function, offsets outside of the file are rejected
 func (fs *MemFile) Seek(offset int64, pos int) (int64, error) {
-	n, err := fs.File.Seek(offset, pos)
+	info, err := fs.File.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "fs.File.Stat")
+	}
+
+	switch pos {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		current, err := fs.File.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return current, errors.Wrap(err, "fs.File.Seek")
+		}
+		offset += current
+	case io.SeekEnd:
+		offset += info.Size()
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+	if offset < 0 || offset > info.Size() {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+
+	n, err := fs.File.Seek(offset, io.SeekStart)
 	if err != nil {
 		return n, errors.Wrap(err, "fs.File.Seek")
 	}
@@ -111,6 +143,33 @@ func (fs *MemFile) Read(b []byte) (cnt int, err error) {
 	return cnt, nil
 }
 
+// ReadAt - read len(b) bytes starting at off through a new
+// handle, so the offset used by Read is not moved and
+// concurrent calls are safe
+func (fs *MemFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+
+	file, err := memFs.Open(fs.FilePath)
+	if err != nil {
+		return 0, errors.Wrap(err, "memFs.Open")
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(off, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "file.Seek")
+	}
+	n, err := io.ReadFull(file, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "io.ReadFull")
+	}
+	return n, nil
+}
+
 // Write - write file in-memory
 func (fs *MemFile) Write(b []byte) (n int, err error) {
 	n, err = fs.File.Write(b)
@@ -120,11 +179,16 @@ func (fs *MemFile) Write(b []byte) (n int, err error) {
 	return n, nil
 }
 
-// Close - close file and execute OnCloseFunc
+// Close - close file and execute OnCloseFunc,
+// closing more than once is a no-op
 func (fs *MemFile) Close() error {
+	if fs.closed {
+		return nil
+	}
 	if err := fs.File.Close(); err != nil {
 		return errors.Wrap(err, "fs.File.Close")
 	}
+	fs.closed = true
 	if fs.OnClose != nil {
 		f, _ := fs.Open(fs.FilePath)
 		if err := fs.OnClose(filepath.Base(fs.FilePath), f); err != nil {
@@ -0,0 +1,28 @@
+package mem
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+func TestConformance(t *testing.T) {
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("/conformance/%d.parquet", files)
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewMemFileWriter(newName(t), nil)
+			if err != nil {
+				t.Fatalf("NewMemFileWriter: %v", err)
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"expvar"
+	"strconv"
+)
+
+// ExpvarRecorder publishes the recorded operations as an expvar variable
+// holding one object per backend and operation
+type ExpvarRecorder struct {
+	*stats
+}
+
+// NewExpvarRecorder publishes a new recorder under name. Like expvar.Publish
+// it panics if name is already in use.
+func NewExpvarRecorder(name string) *ExpvarRecorder {
+	r := &ExpvarRecorder{stats: newStats()}
+	expvar.Publish(name, expvar.Func(r.value))
+	return r
+}
+
+// value returns {backend: {op: {calls, errors, bytes, latency_seconds_sum,
+// latency_seconds_bucket: {le: count}}}}
+func (r *ExpvarRecorder) value() interface{} {
+	keys, values := r.snapshot()
+
+	backends := map[string]map[string]interface{}{}
+	for i, key := range keys {
+		buckets := map[string]int64{}
+		for j, le := range latencyBuckets {
+			buckets[strconv.FormatFloat(le, 'g', -1, 64)] = values[i].buckets[j]
+		}
+
+		if backends[key.backend] == nil {
+			backends[key.backend] = map[string]interface{}{}
+		}
+		backends[key.backend][key.op] = map[string]interface{}{
+			"calls":                  values[i].calls,
+			"errors":                 values[i].errors,
+			"bytes":                  values[i].bytes,
+			"latency_seconds_sum":    values[i].sum,
+			"latency_seconds_bucket": buckets,
+		}
+	}
+	return backends
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+// Operations reported to a Recorder
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpSeek   = "seek"
+	OpOpen   = "open"
+	OpCreate = "create"
+	OpClose  = "close"
+)
+
+// Recorder receives one call per operation made on a MetricsFile
+type Recorder interface {
+	// Record reports an operation of backend that moved bytes bytes, took
+	// latency and failed with err when err is not nil
+	Record(backend string, op string, bytes int, latency time.Duration, err error)
+}
+
+// MetricsFile reports every call made on a ParquetFile to a Recorder
+type MetricsFile struct {
+	source   source.ParquetFile
+	backend  string
+	recorder Recorder
+}
+
+// NewMetricsFile wraps pf, its operations are reported under backend. An
+// empty backend is replaced with the type name of pf. The returned file
+// implements io.ReaderAt and Size() int64 when pf does.
+func NewMetricsFile(pf source.ParquetFile, backend string, recorder Recorder) source.ParquetFile {
+	if backend == "" {
+		backend = fmt.Sprintf("%T", pf)
+	}
+	f := &MetricsFile{
+		source:   pf,
+		backend:  backend,
+		recorder: recorder,
+	}
+
+	_, readerAt := pf.(io.ReaderAt)
+	_, sizer := pf.(sourceutil.Sizer)
+	switch {
+	case readerAt && sizer:
+		return readerAtSizerFile{f}
+	case readerAt:
+		return readerAtFile{f}
+	case sizer:
+		return sizerFile{f}
+	}
+	return f
+}
+
+// readerAtFile is a MetricsFile whose source implements io.ReaderAt
+type readerAtFile struct {
+	*MetricsFile
+}
+
+// ReadAt calls ReadAt on the underlying file
+func (f readerAtFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.readAt(p, off)
+}
+
+// sizerFile is a MetricsFile whose source knows its size
+type sizerFile struct {
+	*MetricsFile
+}
+
+// Size returns the size of the underlying file
+func (f sizerFile) Size() int64 {
+	return f.source.(sourceutil.Sizer).Size()
+}
+
+// readerAtSizerFile is a MetricsFile whose source implements io.ReaderAt
+// and knows its size
+type readerAtSizerFile struct {
+	*MetricsFile
+}
+
+// ReadAt calls ReadAt on the underlying file
+func (f readerAtSizerFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.readAt(p, off)
+}
+
+// Size returns the size of the underlying file
+func (f readerAtSizerFile) Size() int64 {
+	return f.source.(sourceutil.Sizer).Size()
+}
+
+func (f *MetricsFile) record(op string, bytes int, start time.Time, err error) {
+	f.recorder.Record(f.backend, op, bytes, time.Since(start), err)
+}
+
+// Seek calls Seek on the underlying file
+func (f *MetricsFile) Seek(offset int64, whence int) (int64, error) {
+	start := time.Now()
+	n, err := f.source.Seek(offset, whence)
+	f.record(OpSeek, 0, start, err)
+	if err != nil {
+		return n, errors.Wrap(err, "f.source.Seek")
+	}
+	return n, nil
+}
+
+// Read calls Read on the underlying file. Reaching the end of the file is
+// not counted as an error.
+func (f *MetricsFile) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.source.Read(p)
+	if errors.Is(err, io.EOF) {
+		f.record(OpRead, n, start, nil)
+	} else {
+		f.record(OpRead, n, start, err)
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "f.source.Read")
+	}
+	return n, nil
+}
+
+// readAt calls ReadAt on the underlying file, which implements io.ReaderAt.
+// It is reported as a read.
+func (f *MetricsFile) readAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.source.(io.ReaderAt).ReadAt(p, off)
+	if errors.Is(err, io.EOF) {
+		f.record(OpRead, n, start, nil)
+	} else {
+		f.record(OpRead, n, start, err)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return n, err
+		}
+		return n, errors.Wrap(err, "f.source.ReadAt")
+	}
+	return n, nil
+}
+
+// Write calls Write on the underlying file
+func (f *MetricsFile) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.source.Write(p)
+	f.record(OpWrite, n, start, err)
+	if err != nil {
+		return n, errors.Wrap(err, "f.source.Write")
+	}
+	return n, nil
+}
+
+// Close calls Close on the underlying file
+func (f *MetricsFile) Close() error {
+	start := time.Now()
+	err := f.source.Close()
+	f.record(OpClose, 0, start, err)
+	if err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open calls Open on the underlying file, the returned file reports to the
+// same Recorder
+func (f *MetricsFile) Open(name string) (source.ParquetFile, error) {
+	start := time.Now()
+	pf, err := f.source.Open(name)
+	f.record(OpOpen, 0, start, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	return NewMetricsFile(pf, f.backend, f.recorder), nil
+}
+
+// Create calls Create on the underlying file, the returned file reports to
+// the same Recorder
+func (f *MetricsFile) Create(name string) (source.ParquetFile, error) {
+	start := time.Now()
+	pf, err := f.source.Create(name)
+	f.record(OpCreate, 0, start, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Create")
+	}
+	return NewMetricsFile(pf, f.backend, f.recorder), nil
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go-source/s3test"
+	"github.com/sabey/parquet-go-source/s3v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/source"
+	"github.com/sabey/parquet-go/writer"
+)
+
+func TestConformance(t *testing.T) {
+	recorder := NewPrometheusRecorder("")
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			return NewMetricsFile(buffer.NewBufferFileFromBytes(data), "buffer", recorder)
+		},
+	})
+}
+
+type failingFile struct {
+	source.ParquetFile
+}
+
+func (failingFile) Seek(int64, int) (int64, error) {
+	return 0, errors.New("seek failed")
+}
+
+func TestReadAtAndSize(t *testing.T) {
+	recorder := NewPrometheusRecorder("test")
+	data := sourcetest.Data()
+	pf := NewMetricsFile(sizedFile{buffer.NewBufferFileFromBytes(data), int64(len(data))}, "buffer", recorder)
+
+	ra, ok := pf.(io.ReaderAt)
+	if !ok {
+		t.Fatalf("expected io.ReaderAt to be forwarded")
+	}
+	b := make([]byte, 10)
+	if _, err := ra.ReadAt(b, 20); err != nil || !bytes.Equal(b, data[20:30]) {
+		t.Errorf("expected %v but got %v (%v)", data[20:30], b, err)
+	}
+	if size, _ := sourceutil.Size(pf); size != int64(len(data)) {
+		t.Errorf("expected size %d but got %d", len(data), size)
+	}
+	if _, ok := pf.(sourceutil.Sizer); !ok {
+		t.Errorf("expected Size to be forwarded")
+	}
+
+	if _, ok := NewMetricsFile(failingFile{}, "failing", recorder).(io.ReaderAt); ok {
+		t.Errorf("expected io.ReaderAt not to be forwarded for a source without it")
+	}
+	if _, ok := NewMetricsFile(failingFile{}, "failing", recorder).(sourceutil.Sizer); ok {
+		t.Errorf("expected Size not to be forwarded for a source without it")
+	}
+}
+
+type sizedFile struct {
+	*buffer.BufferFile
+	size int64
+}
+
+func (f sizedFile) Size() int64 {
+	return f.size
+}
+
+func TestPrometheusRecorder(t *testing.T) {
+	recorder := NewPrometheusRecorder("test")
+	pf := NewMetricsFile(buffer.NewBufferFileFromBytes(sourcetest.Data()), "buffer", recorder)
+
+	b := make([]byte, 100)
+	for i := 0; i < 3; i++ {
+		if _, err := pf.Read(b); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if _, err := NewMetricsFile(failingFile{}, "failing", recorder).Seek(0, 0); err == nil {
+		t.Fatal("expected Seek to fail")
+	}
+
+	var out bytes.Buffer
+	if _, err := recorder.WriteTo(&out); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for _, line := range []string{
+		`test_operations_total{backend="buffer",op="read"} 3`,
+		`test_bytes_total{backend="buffer",op="read"} 300`,
+		`test_errors_total{backend="buffer",op="read"} 0`,
+		`test_errors_total{backend="failing",op="seek"} 1`,
+		`test_operation_duration_seconds_bucket{backend="buffer",op="read",le="+Inf"} 3`,
+		`test_operation_duration_seconds_count{backend="buffer",op="read"} 3`,
+		"# TYPE test_operation_duration_seconds histogram",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected output to contain %q but got\n%s", line, out.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Body.String() != out.String() {
+		t.Errorf("expected ServeHTTP to write the same output as WriteTo")
+	}
+}
+
+func TestExpvarRecorder(t *testing.T) {
+	recorder := NewExpvarRecorder("parquet_source_test")
+	recorder.Record("s3", OpRead, 42, 3*time.Millisecond, nil)
+	recorder.Record("s3", OpRead, 0, time.Second, errors.New("failed"))
+
+	var got map[string]map[string]struct {
+		Calls   int64            `json:"calls"`
+		Errors  int64            `json:"errors"`
+		Bytes   int64            `json:"bytes"`
+		Buckets map[string]int64 `json:"latency_seconds_bucket"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("parquet_source_test").String()), &got); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	read := got["s3"][OpRead]
+	if read.Calls != 2 || read.Errors != 1 || read.Bytes != 42 {
+		t.Errorf("expected 2 calls, 1 error and 42 bytes but got %+v", read)
+	}
+	if read.Buckets["0.005"] != 1 || read.Buckets["1"] != 2 {
+		t.Errorf("expected cumulative latency buckets but got %v", read.Buckets)
+	}
+}
+
+// TestParquetScan counts the reads of a parquet scan, every Read of the
+// s3v2 source is one ranged GET
+func TestParquetScan(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	server.CreateBucket("test-bucket")
+	client := server.ClientV2()
+	ctx := context.Background()
+
+	fw, err := s3v2.NewS3FileWriterWithClient(ctx, client, "test-bucket", "students.parquet", nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pw, err := writer.NewParquetWriter(fw, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < 100; i++ {
+		if err = pw.Write(sourcetest.Student{Name: "name", ID: int64(i)}); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	s3File, err := s3v2.NewS3FileReaderWithClient(ctx, client, "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	recorder := NewPrometheusRecorder("")
+	server.ResetRequests()
+	pr, err := reader.NewParquetReader(NewMetricsFile(s3File, "s3", recorder), new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	rows := make([]sourcetest.Student, pr.GetNumRows())
+	if err = pr.Read(&rows); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pr.ReadStop()
+
+	var gets int64
+	for _, r := range server.Requests() {
+		if r.Method == http.MethodGet {
+			gets++
+		}
+	}
+	var reads int64
+	keys, values := recorder.snapshot()
+	for i, key := range keys {
+		if key.op == OpRead {
+			reads += values[i].calls
+		}
+	}
+	if gets == 0 || reads != gets {
+		t.Errorf("expected %d reads, one per GET, but got %d", gets, reads)
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusRecorder keeps the recorded operations in memory and renders
+// them in the Prometheus text exposition format. It is an http.Handler that
+// can be mounted on a /metrics endpoint.
+type PrometheusRecorder struct {
+	*stats
+	namespace string
+}
+
+// NewPrometheusRecorder returns a recorder whose metric names start with
+// namespace, "parquet_source" when empty
+func NewPrometheusRecorder(namespace string) *PrometheusRecorder {
+	if namespace == "" {
+		namespace = "parquet_source"
+	}
+	return &PrometheusRecorder{stats: newStats(), namespace: namespace}
+}
+
+// WriteTo writes every metric to w
+func (r *PrometheusRecorder) WriteTo(w io.Writer) (int64, error) {
+	keys, values := r.snapshot()
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	counter := func(name string, help string, value func(s series) int64) {
+		fmt.Fprintf(cw, "# HELP %s_%s %s\n", r.namespace, name, help)
+		fmt.Fprintf(cw, "# TYPE %s_%s counter\n", r.namespace, name)
+		for i, key := range keys {
+			fmt.Fprintf(cw, "%s_%s{%s} %d\n", r.namespace, name, labels(key), value(values[i]))
+		}
+	}
+	counter("operations_total", "Number of operations.", func(s series) int64 { return s.calls })
+	counter("errors_total", "Number of failed operations.", func(s series) int64 { return s.errors })
+	counter("bytes_total", "Number of bytes read or written.", func(s series) int64 { return s.bytes })
+
+	name := r.namespace + "_operation_duration_seconds"
+	fmt.Fprintf(cw, "# HELP %s Latency of the operations.\n", name)
+	fmt.Fprintf(cw, "# TYPE %s histogram\n", name)
+	for i, key := range keys {
+		for j, le := range latencyBuckets {
+			fmt.Fprintf(cw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels(key), strconv.FormatFloat(le, 'g', -1, 64), values[i].buckets[j])
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels(key), values[i].calls)
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", name, labels(key), strconv.FormatFloat(values[i].sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", name, labels(key), values[i].calls)
+	}
+
+	if cw.err != nil {
+		return cw.n, errors.Wrap(cw.err, "w.Write")
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, errors.Wrap(err, "w.Flush")
+	}
+	return cw.n, nil
+}
+
+// ServeHTTP writes the metrics in the text exposition format. The metrics
+// are rendered before the response is started, so that a failure is
+// reported with a 500.
+func (r *PrometheusRecorder) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	// a failed write means the client went away, there is nobody to tell
+	_, _ = buf.WriteTo(w)
+}
+
+// labelEscaper escapes label values as the text exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(key seriesKey) string {
+	return fmt.Sprintf(`backend="%s",op="%s"`, labelEscaper.Replace(key.backend), labelEscaper.Replace(key.op))
+}
+
+// countingWriter counts the bytes written and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
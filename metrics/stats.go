@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms,
+// shared by every series and never modified
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type seriesKey struct {
+	backend string
+	op      string
+}
+
+// series aggregates the operations of one backend and operation
+type series struct {
+	calls  int64
+	errors int64
+	bytes  int64
+	// buckets[i] counts the operations faster than or equal to latencyBuckets[i]
+	buckets []int64
+	sum     float64
+}
+
+// stats aggregates Record calls, it backs the expvar and Prometheus recorders
+type stats struct {
+	lock   sync.Mutex
+	series map[seriesKey]*series
+}
+
+func newStats() *stats {
+	return &stats{series: map[seriesKey]*series{}}
+}
+
+// Record implements Recorder
+func (s *stats) Record(backend string, op string, bytes int, latency time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := seriesKey{backend: backend, op: op}
+	ser, ok := s.series[key]
+	if !ok {
+		ser = &series{buckets: make([]int64, len(latencyBuckets))}
+		s.series[key] = ser
+	}
+
+	ser.calls++
+	if err != nil {
+		ser.errors++
+	}
+	ser.bytes += int64(bytes)
+	seconds := latency.Seconds()
+	ser.sum += seconds
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			ser.buckets[i]++
+		}
+	}
+}
+
+// snapshot returns a copy of every series sorted by backend and operation
+func (s *stats) snapshot() ([]seriesKey, []series) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]seriesKey, 0, len(s.series))
+	for key := range s.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].backend != keys[j].backend {
+			return keys[i].backend < keys[j].backend
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	values := make([]series, len(keys))
+	for i, key := range keys {
+		values[i] = *s.series[key]
+		values[i].buckets = append([]int64(nil), values[i].buckets...)
+	}
+	return keys, values
+}
@@ -0,0 +1,206 @@
+package prefetch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+// DefaultSize is the number of bytes fetched from the end of the file when
+// Options.Size is unset
+const DefaultSize = 64 << 10
+
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+	// ErrNotParquet is returned when the file does not end with a parquet footer
+	ErrNotParquet = errors.New("prefetch: not a parquet file")
+
+	magic = []byte("PAR1")
+)
+
+// Options configure the footer prefetch
+type Options struct {
+	// Size is the number of bytes read from the end of the file when it is
+	// opened. Footers larger than Size cost one additional request.
+	Size int64
+}
+
+// FooterFile keeps the footer of a remote parquet file in memory. The footer
+// is fetched once when the file is opened and shared by every Open("")
+// handle, so reading the metadata never hits the network again.
+type FooterFile struct {
+	source source.ParquetFile
+	footer *footer
+	size   int64
+	offset int64
+}
+
+// footer holds the end of the file starting at offset
+type footer struct {
+	options Options
+	offset  int64
+	data    []byte
+}
+
+// NewFooterFile wraps a ParquetFile opened for reading and prefetches its footer
+func NewFooterFile(pf source.ParquetFile, options Options) (source.ParquetFile, error) {
+	if options.Size <= 0 {
+		options.Size = DefaultSize
+	}
+
+	size, err := sourceutil.Size(pf)
+	if err != nil {
+		return nil, errors.Wrap(err, "sourceutil.Size")
+	}
+	f, err := readFooter(pf, size, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "readFooter")
+	}
+
+	return &FooterFile{
+		source: pf,
+		footer: f,
+		size:   size,
+	}, nil
+}
+
+// readFooter fetches the last options.Size bytes of pf and, if the footer
+// does not fit, the rest of the footer with a second read
+func readFooter(pf source.ParquetFile, size int64, options Options) (*footer, error) {
+	// PAR1 + footer + footer length + PAR1
+	if size < int64(2*len(magic)+4) {
+		return nil, errors.Wrap(ErrNotParquet, "file too small")
+	}
+
+	n := options.Size
+	if n > size {
+		n = size
+	}
+	f := &footer{
+		options: options,
+		offset:  size - n,
+		data:    make([]byte, n),
+	}
+	if _, err := sourceutil.ReadAt(pf, f.data, f.offset); err != nil {
+		return nil, errors.Wrap(err, "sourceutil.ReadAt")
+	}
+
+	if !bytes.Equal(f.data[n-4:], magic) {
+		return nil, errors.Wrap(ErrNotParquet, "invalid magic")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(f.data[n-8 : n-4]))
+	footerStart := size - 8 - footerLen
+	if footerStart < int64(len(magic)) {
+		return nil, errors.Wrapf(ErrNotParquet, "invalid footer length %d", footerLen)
+	}
+
+	if footerStart < f.offset {
+		missing := make([]byte, f.offset-footerStart)
+		if _, err := sourceutil.ReadAt(pf, missing, footerStart); err != nil {
+			return nil, errors.Wrap(err, "sourceutil.ReadAt")
+		}
+		f.data = append(missing, f.data...)
+		f.offset = footerStart
+	}
+	return f, nil
+}
+
+// Size returns the size of the underlying file
+func (f *FooterFile) Size() int64 {
+	return f.size
+}
+
+// Seek sets the offset for the next Read
+func (f *FooterFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+
+	if offset < 0 || offset > f.size {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+// Read up to len(p) bytes into p. Reads inside the prefetched footer are
+// served from memory, everything else is read from the underlying file.
+func (f *FooterFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if f.offset >= f.footer.offset {
+		n = copy(p, f.footer.data[f.offset-f.footer.offset:])
+	} else {
+		n, err = sourceutil.ReadAt(f.source, p, f.offset)
+	}
+	f.offset += int64(n)
+
+	if err != nil {
+		return n, errors.Wrap(err, "sourceutil.ReadAt")
+	}
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// Write is not supported, use Create on the underlying file to write
+func (f *FooterFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("FooterFile does not support Write()")
+}
+
+// Close closes the underlying file
+func (f *FooterFile) Close() error {
+	if err := f.source.Close(); err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open opens the underlying file again. Opening the same file (an empty
+// name) reuses the prefetched footer.
+func (f *FooterFile) Open(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	if name != "" {
+		prefetched, err := NewFooterFile(pf, f.footer.options)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewFooterFile")
+		}
+		return prefetched, nil
+	}
+
+	return &FooterFile{
+		source: pf,
+		footer: f.footer,
+		size:   f.size,
+	}, nil
+}
+
+// Create delegates to the underlying file
+func (f *FooterFile) Create(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Create(name)
+	if err != nil {
+		return pf, errors.Wrap(err, "f.source.Create")
+	}
+	return pf, nil
+}
@@ -0,0 +1,136 @@
+package prefetch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	"github.com/sabey/parquet-go-source/s3test"
+	"github.com/sabey/parquet-go-source/s3v2"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/writer"
+)
+
+const numRows = 1000
+
+func parquetData(t *testing.T) []byte {
+	bf := buffer.NewBufferFile()
+	pw, err := writer.NewParquetWriter(bf, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < numRows; i++ {
+		if err = pw.Write(sourcetest.Student{Name: "name", Age: int32(i), ID: int64(i)}); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err = pw.WriteStop(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return bf.Bytes()
+}
+
+func TestFooterIsReadOnce(t *testing.T) {
+	testcases := []struct {
+		name         string
+		size         int64
+		prefetchGets int
+	}{
+		{"footer fits", 0, 1},
+		{"footer larger than prefetch", 16, 2},
+	}
+
+	server := s3test.NewServer()
+	defer server.Close()
+	data := parquetData(t)
+	server.PutObject("test-bucket", "students.parquet", data)
+	client := server.ClientV2()
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s3File, err := s3v2.NewS3FileReaderWithClient(context.Background(), client, "test-bucket", "students.parquet")
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			server.ResetRequests()
+			pf, err := NewFooterFile(s3File, Options{Size: tc.size})
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if gets := len(server.Gets()); gets != tc.prefetchGets {
+				t.Errorf("expected %d GETs to prefetch the footer but got %d", tc.prefetchGets, gets)
+			}
+
+			server.ResetRequests()
+			pr, err := reader.NewParquetReader(pf, new(sourcetest.Student), 1)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if gets := len(server.Gets()); gets != 0 {
+				t.Errorf("expected metadata to be read from memory but got %d GETs", gets)
+			}
+
+			rows := make([]sourcetest.Student, pr.GetNumRows())
+			if err = pr.Read(&rows); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			pr.ReadStop()
+			if len(rows) != numRows || rows[numRows-1].ID != numRows-1 {
+				t.Errorf("expected to read back %d rows but got %d", numRows, len(rows))
+			}
+		})
+	}
+}
+
+func TestSmallFileIsFullyPrefetched(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	data := parquetData(t)
+	server.PutObject("test-bucket", "students.parquet", data)
+
+	s3File, err := s3v2.NewS3FileReaderWithClient(context.Background(), server.ClientV2(), "test-bucket", "students.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.ResetRequests()
+	pf, err := NewFooterFile(s3File, Options{Size: int64(len(data)) * 2})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	pr, err := reader.NewParquetReader(pf, new(sourcetest.Student), 1)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	rows := make([]sourcetest.Student, pr.GetNumRows())
+	if err = pr.Read(&rows); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	pr.ReadStop()
+	if gets := len(server.Gets()); gets != 1 {
+		t.Errorf("expected the whole file to be read with 1 GET but got %d", gets)
+	}
+}
+
+func TestNotParquet(t *testing.T) {
+	testcases := []struct {
+		name string
+		data []byte
+	}{
+		{"too small", []byte("PAR1")},
+		{"no magic", sourcetest.Data()},
+		{"footer length past start", append(append([]byte("PAR1"), 0xff, 0xff, 0, 0), []byte("PAR1")...)},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewFooterFile(buffer.NewBufferFileFromBytes(tc.data), Options{})
+			if !errors.Is(err, ErrNotParquet) {
+				t.Errorf("expected error to be %v but got %v", ErrNotParquet, err)
+			}
+		})
+	}
+}
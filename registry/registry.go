@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/ncw/swift"
+	"github.com/pkg/errors"
+	azblobsource "github.com/sabey/parquet-go-source/azblob"
+	"github.com/sabey/parquet-go-source/gcs"
+	"github.com/sabey/parquet-go-source/hdfs"
+	httpsource "github.com/sabey/parquet-go-source/http"
+	"github.com/sabey/parquet-go-source/local"
+	"github.com/sabey/parquet-go-source/mem"
+	"github.com/sabey/parquet-go-source/s3v2"
+	swiftsource "github.com/sabey/parquet-go-source/swift"
+	"github.com/sabey/parquet-go/source"
+)
+
+// OpenFunc opens the ParquetFile located at u
+type OpenFunc func(ctx context.Context, u *url.URL) (source.ParquetFile, error)
+
+type scheme struct {
+	reader OpenFunc
+	writer OpenFunc
+}
+
+var (
+	ErrUnknownScheme = errors.New("registry: unknown scheme")
+	ErrNoReader      = errors.New("registry: scheme does not support reading")
+	ErrNoWriter      = errors.New("registry: scheme does not support writing")
+
+	schemes   = map[string]scheme{}
+	schemesMu sync.RWMutex
+)
+
+func init() {
+	Register("file", openLocalReader, openLocalWriter)
+	Register("s3", openS3Reader, openS3Writer)
+	Register("gs", openGcsReader, openGcsWriter)
+	Register("hdfs", openHdfsReader, openHdfsWriter)
+	Register("wasb", openAzBlobReader, openAzBlobWriter)
+	Register("wasbs", openAzBlobReader, openAzBlobWriter)
+	Register("swift", openSwiftReader, openSwiftWriter)
+	Register("http", openHttpReader, openHttpWriter)
+	Register("https", openHttpReader, openHttpWriter)
+	Register("mem", openMemReader, openMemWriter)
+}
+
+// Register makes a scheme available to NewFileReader and NewFileWriter.
+// Registering an existing scheme replaces it, so the built-in schemes can be
+// overridden with differently configured clients. Either function may be nil
+// if the scheme only supports one direction.
+func Register(name string, reader OpenFunc, writer OpenFunc) {
+	schemesMu.Lock()
+	schemes[strings.ToLower(name)] = scheme{reader: reader, writer: writer}
+	schemesMu.Unlock()
+}
+
+// Schemes returns the names of all registered schemes
+func Schemes() []string {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	names := make([]string, 0, len(schemes))
+	for name := range schemes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// NewFileReader opens the ParquetFile at uri, to be used with NewParquetReader.
+// A uri without a scheme is treated as a local path.
+func NewFileReader(ctx context.Context, uri string) (source.ParquetFile, error) {
+	u, s, err := lookup(uri)
+	if err != nil {
+		return nil, errors.Wrap(err, "lookup")
+	}
+	if s.reader == nil {
+		return nil, errors.Wrap(ErrNoReader, u.Scheme)
+	}
+	pf, err := s.reader(ctx, u)
+	if err != nil {
+		return pf, errors.Wrap(err, u.Scheme)
+	}
+	return pf, nil
+}
+
+// NewFileWriter creates the ParquetFile at uri, to be used with NewParquetWriter.
+// A uri without a scheme is treated as a local path.
+func NewFileWriter(ctx context.Context, uri string) (source.ParquetFile, error) {
+	u, s, err := lookup(uri)
+	if err != nil {
+		return nil, errors.Wrap(err, "lookup")
+	}
+	if s.writer == nil {
+		return nil, errors.Wrap(ErrNoWriter, u.Scheme)
+	}
+	pf, err := s.writer(ctx, u)
+	if err != nil {
+		return pf, errors.Wrap(err, u.Scheme)
+	}
+	return pf, nil
+}
+
+func lookup(uri string) (*url.URL, scheme, error) {
+	var (
+		u   *url.URL
+		err error
+	)
+	if strings.Contains(uri, "://") {
+		if u, err = url.Parse(uri); err != nil {
+			return nil, scheme{}, errors.Wrap(err, "url.Parse")
+		}
+	} else {
+		// plain paths are not parsed so that names containing '%', '?' or a
+		// windows drive letter are kept intact
+		u = &url.URL{Scheme: "file", Path: uri}
+	}
+
+	schemesMu.RLock()
+	s, ok := schemes[strings.ToLower(u.Scheme)]
+	schemesMu.RUnlock()
+	if !ok {
+		return nil, scheme{}, errors.Wrap(ErrUnknownScheme, u.Scheme)
+	}
+	return u, s, nil
+}
+
+// objectKey returns the path of u without its leading slash
+func objectKey(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+func localPath(u *url.URL) string {
+	if u.Host != "" && u.Host != "localhost" {
+		// file://relative/path.parquet
+		return path.Join(u.Host, u.Path)
+	}
+	return u.Path
+}
+
+func openLocalReader(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	return local.NewLocalFileReader(localPath(u))
+}
+
+func openLocalWriter(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	return local.NewLocalFileWriter(localPath(u))
+}
+
+// s3://bucket/key
+func openS3Reader(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return s3v2.NewS3FileReader(ctx, u.Host, objectKey(u))
+}
+
+func openS3Writer(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return s3v2.NewS3FileWriter(ctx, u.Host, objectKey(u), nil)
+}
+
+// gs://bucket/name
+func openGcsReader(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return gcs.NewGcsFileReader(ctx, "", u.Host, objectKey(u))
+}
+
+func openGcsWriter(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return gcs.NewGcsFileWriter(ctx, "", u.Host, objectKey(u))
+}
+
+// hdfs://user@namenode:port/path
+func openHdfsReader(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return hdfs.NewHdfsFileReaderWithContext(ctx, []string{u.Host}, u.User.Username(), u.Path)
+}
+
+func openHdfsWriter(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return hdfs.NewHdfsFileWriterWithContext(ctx, []string{u.Host}, u.User.Username(), u.Path)
+}
+
+// azBlobURL converts wasbs://container@account.blob.core.windows.net/path
+// into https://account.blob.core.windows.net/container/path
+func azBlobURL(u *url.URL) (string, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return "", errors.Errorf("missing container in %s", u.Redacted())
+	}
+	scheme := "https"
+	if strings.ToLower(u.Scheme) == "wasb" {
+		scheme = "http"
+	}
+	blobURL := url.URL{
+		Scheme: scheme,
+		Host:   u.Host,
+		Path:   "/" + u.User.Username() + "/" + objectKey(u),
+	}
+	return blobURL.String(), nil
+}
+
+// azBlobCredential uses the AZURE_STORAGE_KEY environment variable when set
+// and falls back to anonymous access otherwise
+func azBlobCredential(u *url.URL) (azblob.Credential, error) {
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	if key == "" {
+		return azblob.NewAnonymousCredential(), nil
+	}
+	account := strings.SplitN(u.Hostname(), ".", 2)[0]
+	credential, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "azblob.NewSharedKeyCredential")
+	}
+	return credential, nil
+}
+
+func openAzBlobReader(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	blobURL, err := azBlobURL(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "azBlobURL")
+	}
+	credential, err := azBlobCredential(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "azBlobCredential")
+	}
+	return azblobsource.NewAzBlobFileReader(ctx, blobURL, credential, azblobsource.ReaderOptions{})
+}
+
+func openAzBlobWriter(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	blobURL, err := azBlobURL(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "azBlobURL")
+	}
+	credential, err := azBlobCredential(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "azBlobCredential")
+	}
+	return azblobsource.NewAzBlobFileWriter(ctx, blobURL, credential, azblobsource.WriterOptions{})
+}
+
+// swiftConnection authenticates using the standard OS_* / ST_* environment variables
+func swiftConnection() (*swift.Connection, error) {
+	conn := &swift.Connection{}
+	if err := conn.ApplyEnvironment(); err != nil {
+		return nil, errors.Wrap(err, "conn.ApplyEnvironment")
+	}
+	if err := conn.Authenticate(); err != nil {
+		return nil, errors.Wrap(err, "conn.Authenticate")
+	}
+	return conn, nil
+}
+
+// swift://container/path
+func openSwiftReader(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	conn, err := swiftConnection()
+	if err != nil {
+		return nil, errors.Wrap(err, "swiftConnection")
+	}
+	return swiftsource.NewSwiftFileReader(u.Host, objectKey(u), conn)
+}
+
+func openSwiftWriter(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	conn, err := swiftConnection()
+	if err != nil {
+		return nil, errors.Wrap(err, "swiftConnection")
+	}
+	return swiftsource.NewSwiftFileWriter(u.Host, objectKey(u), conn)
+}
+
+func openHttpReader(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return httpsource.NewHttpReaderWithOptions(u.String(), httpsource.HttpReaderOptions{Context: ctx})
+}
+
+// the file is streamed with a single PUT request
+func openHttpWriter(ctx context.Context, u *url.URL) (source.ParquetFile, error) {
+	return httpsource.NewHttpWriter(u.String(), httpsource.HttpWriterOptions{Context: ctx})
+}
+
+// mem://name
+func memPath(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+func openMemReader(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	if mem.GetMemFileFs() == nil {
+		return nil, errors.New("in-memory file-system has not been initialised")
+	}
+	return (&mem.MemFile{}).Open(memPath(u))
+}
+
+func openMemWriter(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+	return mem.NewMemFileWriter(memPath(u), nil)
+}
@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	httpsource "github.com/sabey/parquet-go-source/http"
+	"github.com/sabey/parquet-go-source/local"
+	"github.com/sabey/parquet-go/source"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("PAR1 some data PAR1")
+
+	testcases := []struct {
+		name string
+		uri  string
+	}{
+		{"plain path", filepath.Join(dir, "plain.parquet")},
+		{"file scheme", "file://" + filepath.Join(dir, "scheme.parquet")},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fw, err := NewFileWriter(context.Background(), tc.uri)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, ok := fw.(*local.LocalFile); !ok {
+				t.Errorf("expected parquet file to be of type %T but got %T", &local.LocalFile{}, fw)
+			}
+			if _, err = fw.Write(data); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if err = fw.Close(); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			fr, err := NewFileReader(context.Background(), tc.uri)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			defer fr.Close()
+			b := make([]byte, len(data))
+			if _, err = fr.Read(b); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if string(b) != string(data) {
+				t.Errorf("expected data to be %q but got %q", data, b)
+			}
+		})
+	}
+}
+
+func TestUnknownScheme(t *testing.T) {
+	_, err := NewFileReader(context.Background(), "nope://bucket/key")
+	if !errors.Is(err, ErrUnknownScheme) {
+		t.Errorf("expected error to be %v but got %v", ErrUnknownScheme, err)
+	}
+}
+
+func TestHttpWriter(t *testing.T) {
+	var method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	pf, err := NewFileWriter(context.Background(), server.URL+"/file.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, ok := pf.(*httpsource.HttpWriter); !ok {
+		t.Errorf("expected parquet file to be of type %T but got %T", &httpsource.HttpWriter{}, pf)
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if method != http.MethodPut {
+		t.Errorf("expected a %s request but got %q", http.MethodPut, method)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var opened *url.URL
+	Register("Custom", func(_ context.Context, u *url.URL) (source.ParquetFile, error) {
+		opened = u
+		return buffer.NewBufferFile(), nil
+	}, nil)
+
+	pf, err := NewFileReader(context.Background(), "custom://host/some/key.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, ok := pf.(*buffer.BufferFile); !ok {
+		t.Errorf("expected parquet file to be of type %T but got %T", &buffer.BufferFile{}, pf)
+	}
+	if opened == nil || opened.Host != "host" || opened.Path != "/some/key.parquet" {
+		t.Errorf("expected opener to receive the parsed uri but got %v", opened)
+	}
+
+	_, err = NewFileWriter(context.Background(), "custom://host/some/key.parquet")
+	if !errors.Is(err, ErrNoWriter) {
+		t.Errorf("expected error to be %v but got %v", ErrNoWriter, err)
+	}
+}
+
+func TestAzBlobURL(t *testing.T) {
+	testcases := []struct {
+		uri      string
+		expected string
+		err      bool
+	}{
+		{"wasbs://container@account.blob.core.windows.net/dir/file.parquet", "https://account.blob.core.windows.net/container/dir/file.parquet", false},
+		{"wasb://container@account.blob.core.windows.net/file.parquet", "http://account.blob.core.windows.net/container/file.parquet", false},
+		{"wasbs://account.blob.core.windows.net/file.parquet", "", true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.uri, func(t *testing.T) {
+			u, err := url.Parse(tc.uri)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			blobURL, err := azBlobURL(u)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error %t but got %v", tc.err, err)
+			}
+			if blobURL != tc.expected {
+				t.Errorf("expected url %q but got %q", tc.expected, blobURL)
+			}
+		})
+	}
+}
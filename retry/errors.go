@@ -0,0 +1,89 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/ncw/swift"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+// retryableCodes are the error codes returned by AWS when a request is
+// throttled or the service has a transient failure
+var retryableCodes = map[string]bool{
+	"Throttling":              true,
+	"ThrottlingException":     true,
+	"ThrottledException":      true,
+	"SlowDown":                true,
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+	"InternalError":           true,
+	"ServiceUnavailable":      true,
+}
+
+// IsRetryable reports whether err is a transient failure of one of the
+// backends: a 5xx, 429 or 408 HTTP status, AWS throttling, or a network
+// timeout or reset
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, io.EOF) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// s3 (awserr.RequestFailure) and http
+	var statusCoder interface{ StatusCode() int }
+	if errors.As(err, &statusCoder) && retryableStatus(statusCoder.StatusCode()) {
+		return true
+	}
+	// s3v2 (smithy-go)
+	var httpStatusCoder interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpStatusCoder) && retryableStatus(httpStatusCoder.HTTPStatusCode()) {
+		return true
+	}
+	// azblob
+	var responder interface{ Response() *http.Response }
+	if errors.As(err, &responder) && responder.Response() != nil && retryableStatus(responder.Response().StatusCode) {
+		return true
+	}
+	// gcs
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && retryableStatus(apiErr.Code) {
+		return true
+	}
+	// swift
+	var swiftErr *swift.Error
+	if errors.As(err, &swiftErr) && retryableStatus(swiftErr.StatusCode) {
+		return true
+	}
+
+	// s3 and s3v2 error codes
+	var coder interface{ Code() string }
+	if errors.As(err, &coder) && retryableCodes[coder.Code()] {
+		return true
+	}
+	var errorCoder interface{ ErrorCode() string }
+	if errors.As(err, &errorCoder) && retryableCodes[errorCoder.ErrorCode()] {
+		return true
+	}
+
+	// hdfs and every backend talking over the network
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if os.IsTimeout(errors.Cause(err)) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
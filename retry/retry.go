@@ -0,0 +1,212 @@
+package retry
+
+import (
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go/source"
+)
+
+const (
+	// DefaultMaxAttempts is the number of attempts used when Options.MaxAttempts is unset
+	DefaultMaxAttempts = 5
+	// DefaultInitialBackoff is the first backoff used when Options.InitialBackoff is unset
+	DefaultInitialBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff caps the backoff when Options.MaxBackoff is unset
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+var (
+	errWhence        = errors.New("Seek: invalid whence")
+	errInvalidOffset = errors.New("Seek: invalid offset")
+)
+
+// Options configure the retries
+type Options struct {
+	// MaxAttempts is the number of times an operation is tried, including
+	// the first attempt
+	MaxAttempts int
+	// InitialBackoff is the upper bound of the wait before the first retry,
+	// it doubles on every retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff time.Duration
+	// Retryable reports whether an error is transient, IsRetryable is used
+	// when nil
+	Retryable func(error) bool
+
+	// sleep is replaced in tests
+	sleep func(time.Duration)
+}
+
+// RetryFile retries the failed reads of a ParquetFile with exponential
+// backoff and jitter. Reads are made at an absolute offset, so a read that
+// fails halfway resumes where it stopped.
+type RetryFile struct {
+	source  source.ParquetFile
+	options Options
+	size    int64
+	offset  int64
+}
+
+// NewRetryFile wraps a ParquetFile opened for reading
+func NewRetryFile(pf source.ParquetFile, options Options) (source.ParquetFile, error) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultInitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultMaxBackoff
+	}
+	if options.Retryable == nil {
+		options.Retryable = IsRetryable
+	}
+	if options.sleep == nil {
+		options.sleep = time.Sleep
+	}
+
+	var size int64
+	err := do(options, func() error {
+		var err error
+		size, err = sourceutil.Size(pf)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "sourceutil.Size")
+	}
+
+	return &RetryFile{
+		source:  pf,
+		options: options,
+		size:    size,
+	}, nil
+}
+
+// do calls f until it succeeds, fails with an error that is not retryable
+// or MaxAttempts is reached
+func do(options Options, f func() error) error {
+	backoff := options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= options.MaxAttempts || !options.Retryable(err) {
+			return err
+		}
+
+		// full jitter
+		options.sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		backoff *= 2
+		if backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+}
+
+// Size returns the size of the underlying file
+func (f *RetryFile) Size() int64 {
+	return f.size
+}
+
+// Seek sets the offset for the next Read
+func (f *RetryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.Wrap(errWhence, "errWhence")
+	}
+
+	if offset < 0 || offset > f.size {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	f.offset = offset
+	return f.offset, nil
+}
+
+// Read up to len(p) bytes into p. Each attempt seeks the underlying file to
+// the first byte not read yet. io.EOF is returned when p could not be
+// filled.
+func (f *RetryFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	end := int64(len(p))
+	if f.offset+end > f.size {
+		end = f.size - f.offset
+	}
+
+	var n int
+	err := do(f.options, func() error {
+		m, err := sourceutil.ReadAt(f.source, p[n:end], f.offset+int64(n))
+		n += m
+		if errors.Is(err, io.EOF) && int64(n) == end {
+			return nil
+		}
+		return err
+	})
+	f.offset += int64(n)
+	if err != nil {
+		return n, errors.Wrap(err, "sourceutil.ReadAt")
+	}
+
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// Write is not supported, writes are not idempotent and cannot be retried
+func (f *RetryFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("RetryFile does not support Write()")
+}
+
+// Close closes the underlying file
+func (f *RetryFile) Close() error {
+	if err := f.source.Close(); err != nil {
+		return errors.Wrap(err, "f.source.Close")
+	}
+	return nil
+}
+
+// Open opens the underlying file again, retrying transient failures
+func (f *RetryFile) Open(name string) (source.ParquetFile, error) {
+	var pf source.ParquetFile
+	err := do(f.options, func() error {
+		var err error
+		pf, err = f.source.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "f.source.Open")
+	}
+	if name != "" {
+		retried, err := NewRetryFile(pf, f.options)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewRetryFile")
+		}
+		return retried, nil
+	}
+
+	return &RetryFile{
+		source:  pf,
+		options: f.options,
+		size:    f.size,
+	}, nil
+}
+
+// Create delegates to the underlying file, writes are not retried
+func (f *RetryFile) Create(name string) (source.ParquetFile, error) {
+	pf, err := f.source.Create(name)
+	if err != nil {
+		return pf, errors.Wrap(err, "f.source.Create")
+	}
+	return pf, nil
+}
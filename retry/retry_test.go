@@ -0,0 +1,191 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/buffer"
+	sourcehttp "github.com/sabey/parquet-go-source/http"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+	"google.golang.org/api/googleapi"
+)
+
+type statusError int
+
+func (e statusError) Error() string {
+	return http.StatusText(int(e))
+}
+
+func (e statusError) StatusCode() int {
+	return int(e)
+}
+
+// flakyFile fails the next failures reads with err, after reading half of
+// the requested bytes
+type flakyFile struct {
+	source.ParquetFile
+	failures int
+	err      error
+	reads    int
+}
+
+func (f *flakyFile) Read(p []byte) (int, error) {
+	f.reads++
+	if f.failures > 0 {
+		f.failures--
+		n, _ := f.ParquetFile.Read(p[:len(p)/2])
+		return n, f.err
+	}
+	return f.ParquetFile.Read(p)
+}
+
+func testOptions() Options {
+	return Options{
+		sleep: func(time.Duration) {},
+	}
+}
+
+func TestConformance(t *testing.T) {
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			pf, err := NewRetryFile(buffer.NewBufferFileFromBytes(data), testOptions())
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+	})
+}
+
+func TestRetry(t *testing.T) {
+	data := sourcetest.Data()
+	flaky := &flakyFile{
+		ParquetFile: buffer.NewBufferFileFromBytes(data),
+		failures:    2,
+		err:         statusError(http.StatusServiceUnavailable),
+	}
+	var sleeps []time.Duration
+	options := testOptions()
+	options.InitialBackoff = time.Second
+	options.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+	pf, err := NewRetryFile(flaky, options)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	if _, err := pf.Seek(10, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, 100)
+	n, err := pf.Read(b)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n != len(b) || !bytes.Equal(b, data[10:110]) {
+		t.Errorf("expected to read data[10:110] but got %d bytes %v", n, b[:n])
+	}
+	if flaky.reads != 3 {
+		t.Errorf("expected 3 reads but got %d", flaky.reads)
+	}
+	if len(sleeps) != 2 {
+		t.Fatalf("expected 2 backoffs but got %d", len(sleeps))
+	}
+	if sleeps[0] > time.Second || sleeps[1] > 2*time.Second {
+		t.Errorf("expected backoffs up to 1s and 2s but got %v", sleeps)
+	}
+
+	n, err = pf.Read(b)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b[:n], data[110:210]) {
+		t.Errorf("expected to read data[110:210] but got %v", b[:n])
+	}
+}
+
+func TestNotRetryable(t *testing.T) {
+	flaky := &flakyFile{
+		ParquetFile: buffer.NewBufferFileFromBytes(sourcetest.Data()),
+		failures:    1,
+		err:         statusError(http.StatusForbidden),
+	}
+	pf, err := NewRetryFile(flaky, testOptions())
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	_, err = pf.Read(make([]byte, 100))
+	if !errors.Is(err, statusError(http.StatusForbidden)) {
+		t.Errorf("expected error to be %q but got %v", statusError(http.StatusForbidden), err)
+	}
+	if flaky.reads != 1 {
+		t.Errorf("expected 1 read but got %d", flaky.reads)
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	flaky := &flakyFile{
+		ParquetFile: buffer.NewBufferFileFromBytes(sourcetest.Data()),
+		failures:    10,
+		err:         statusError(http.StatusTooManyRequests),
+	}
+	options := testOptions()
+	options.MaxAttempts = 3
+	pf, err := NewRetryFile(flaky, options)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	_, err = pf.Read(make([]byte, 100))
+	if !errors.Is(err, statusError(http.StatusTooManyRequests)) {
+		t.Errorf("expected error to be %q but got %v", statusError(http.StatusTooManyRequests), err)
+	}
+	if flaky.reads != 3 {
+		t.Errorf("expected 3 reads but got %d", flaky.reads)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{io.EOF, false},
+		{errors.Wrap(io.EOF, "io.EOF"), false},
+		{context.Canceled, false},
+		{errors.New("not found"), false},
+		{statusError(http.StatusNotFound), false},
+		{statusError(http.StatusInternalServerError), true},
+		{errors.Wrap(statusError(http.StatusServiceUnavailable), "Read"), true},
+		{statusError(http.StatusTooManyRequests), true},
+		{&sourcehttp.StatusError{Code: http.StatusBadGateway}, true},
+		{&sourcehttp.StatusError{Code: http.StatusNotFound}, false},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, ""), true},
+		{awserr.New("Throttling", "rate exceeded", nil), true},
+		{awserr.NewRequestFailure(awserr.New("NoSuchKey", "not found", nil), http.StatusNotFound, ""), false},
+		{&googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{errors.Wrap(timeoutError{}, "hdfs"), true},
+		{io.ErrUnexpectedEOF, true},
+	} {
+		if retryable := IsRetryable(test.err); retryable != test.retryable {
+			t.Errorf("expected IsRetryable(%v) to be %t but got %t", test.err, test.retryable, retryable)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/checksum"
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+var (
+	// ErrChecksumMismatch is returned by the read completing a sequential
+	// scan of an object when the bytes read do not match its checksum
+	ErrChecksumMismatch = checksum.ErrMismatch
+	// ErrChecksumUnavailable is returned when opening a reader created with
+	// WithVerifyChecksum for an object that has no checksum to verify
+	ErrChecksumUnavailable = checksum.ErrUnavailable
+)
+
+// Algorithms of the checksums sent with every body of an upload, see
+// WithChecksumAlgorithm
+const (
+	ChecksumCRC32C = checksum.CRC32C
+	ChecksumSHA256 = checksum.SHA256
+)
+
+// Checksums of the bytes written to an S3File, complete once Close returned.
+// They are only computed when the writer was created with
+// WithChecksumAlgorithm.
+type Checksums struct {
+	// Algorithm is the one of the checksum sent with every body and checked
+	// by S3
+	Algorithm string
+	// CRC32C and SHA256 are base64 encoded, like the x-amz-checksum headers.
+	// The checksum of a multipart upload is the checksum of the checksums
+	// of its parts followed by their number, as in "...-3". S3 returns the
+	// one of Algorithm for the object.
+	CRC32C string
+	SHA256 string
+	// ETag is computed from the MD5 of the uploaded parts, each part being
+	// sent with its Content-MD5. S3 gives the same ETag to objects that are
+	// not encrypted with KMS or a customer key.
+	ETag string
+}
+
+// Checksums returns the checksums of the bytes written to s
+func (s *S3File) Checksums() Checksums {
+	if s.parts == nil {
+		return Checksums{}
+	}
+	return Checksums{
+		Algorithm: s.checksumAlgorithm,
+		CRC32C:    s.parts.CRC32C(),
+		SHA256:    s.parts.SHA256(),
+		ETag:      s.parts.ETag(),
+	}
+}
+
+// WithChecksumAlgorithm sends the checksum of algorithm, ChecksumCRC32C or
+// ChecksumSHA256, with every body of the upload so that S3 rejects corrupted
+// parts and stores the checksum of the object. No checksum is sent by
+// default, as some S3 compatible servers do not support them.
+func WithChecksumAlgorithm(algorithm string) WriterOption {
+	return func(s *S3File) {
+		s.checksumAlgorithm = algorithm
+	}
+}
+
+// WithVerifyChecksum makes a reader compare the bytes of a sequential scan of
+// the object, from its first to its last byte, with the CRC32C or SHA-256
+// checksum S3 stores for it. The read reaching the end fails with
+// ErrChecksumMismatch when they differ. Objects written without a checksum
+// are compared with their ETag, and opening one fails with
+// ErrChecksumUnavailable when it is encrypted with KMS or a customer key, as
+// its ETag is not its MD5.
+func WithVerifyChecksum() ReaderOption {
+	return func(s *S3File) {
+		s.verifyChecksum = true
+	}
+}
+
+// checksumClient sends the Content-MD5 and the checksum of algorithm of
+// every body the uploader sends, and records them to compute the checksums
+// of the object. The upload is created with algorithm, headers and elements
+// the SDK does not know about. It is only used when an algorithm is set.
+type checksumClient struct {
+	s3iface.S3API
+	parts     *checksum.Parts
+	algorithm string
+}
+
+// PutObjectRequest is used by the uploader for single part uploads
+func (c checksumClient) PutObjectRequest(in *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	if in.Body == nil {
+		return c.S3API.PutObjectRequest(in)
+	}
+	sums, err := c.parts.Add(0, in.Body)
+	if err != nil {
+		req, out := c.S3API.PutObjectRequest(in)
+		req.Error = errors.Wrap(err, "c.parts.Add")
+		return req, out
+	}
+	if in.ContentMD5 == nil {
+		in.ContentMD5 = aws.String(sums.MD5)
+	}
+	req, out := c.S3API.PutObjectRequest(in)
+	req.ApplyOptions(c.sumHeader(sums))
+	return req, out
+}
+
+func (c checksumClient) CreateMultipartUploadWithContext(ctx aws.Context, in *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	opts = append(opts, request.WithSetRequestHeaders(map[string]string{
+		"X-Amz-Checksum-Algorithm": c.algorithm,
+	}))
+	return c.S3API.CreateMultipartUploadWithContext(ctx, in, opts...)
+}
+
+func (c checksumClient) UploadPartWithContext(ctx aws.Context, in *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	if in.Body != nil {
+		sums, err := c.parts.Add(aws.Int64Value(in.PartNumber), in.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "c.parts.Add")
+		}
+		in.ContentMD5 = aws.String(sums.MD5)
+		opts = append(opts, c.sumHeader(sums))
+	}
+	return c.S3API.UploadPartWithContext(ctx, in, opts...)
+}
+
+// CompleteMultipartUploadWithContext replaces the body built by the SDK with
+// one listing the checksum of every part
+func (c checksumClient) CompleteMultipartUploadWithContext(ctx aws.Context, in *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	if in.MultipartUpload != nil {
+		parts := make([]checksum.CompletedPart, len(in.MultipartUpload.Parts))
+		for i, part := range in.MultipartUpload.Parts {
+			parts[i] = checksum.CompletedPart{Number: aws.Int64Value(part.PartNumber), ETag: aws.StringValue(part.ETag)}
+		}
+		body, err := c.parts.CompleteMultipartUpload(c.algorithm, parts)
+		if err != nil {
+			return nil, errors.Wrap(err, "c.parts.CompleteMultipartUpload")
+		}
+		opts = append(opts, func(r *request.Request) {
+			r.Handlers.Build.PushBack(func(r *request.Request) {
+				r.SetBufferBody(body)
+			})
+		})
+	}
+	return c.S3API.CompleteMultipartUploadWithContext(ctx, in, opts...)
+}
+
+// sumHeader sets the header carrying the checksum of a body
+func (c checksumClient) sumHeader(sums checksum.Sums) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set(checksum.Header(c.algorithm), sums.Get(c.algorithm))
+	}
+}
+
+// checksumMode makes S3 return the stored checksum of an object
+var checksumMode = request.WithSetRequestHeaders(map[string]string{"X-Amz-Checksum-Mode": "ENABLED"})
+
+// responseHeader stores the header of the response in header, unlike
+// request.WithGetResponseHeaders it allows requests failing without one
+func responseHeader(header *http.Header) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.HTTPResponse != nil {
+				*header = r.HTTPResponse.Header
+			}
+		})
+	}
+}
+
+// storedChecksum returns the checksum of the object described by hoo and
+// header, the response to a HeadObject sent with checksumMode
+func storedChecksum(hoo *s3.HeadObjectOutput, header http.Header) (checksum.Sum, error) {
+	encrypted := aws.StringValue(hoo.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms || hoo.SSECustomerAlgorithm != nil
+	sum, err := checksum.Stored(header, aws.StringValue(hoo.ETag), encrypted)
+	if err != nil {
+		return checksum.Sum{}, errors.Wrap(err, "checksum.Stored")
+	}
+	return sum, nil
+}
+
+// verify passes the bytes read at off to the verifier of s, which is started
+// by a read at the start of the object
+func (s *S3File) verify(off int64, p []byte) error {
+	if s.verifier == nil {
+		if off != 0 || s.fileSize == 0 {
+			return nil
+		}
+
+		var partSize int64
+		if s.stored.Multipart() {
+			// every part but the last one has the size of the first one
+			hoi := &s3.HeadObjectInput{
+				Bucket:     aws.String(s.BucketName),
+				Key:        aws.String(s.Key),
+				VersionId:  s.VersionId,
+				IfMatch:    aws.String(s.ETag),
+				PartNumber: aws.Int64(1),
+			}
+			ctx, span := trace.Start(s.ctx, "s3.HeadObject", objectAttributes(hoi.Bucket, hoi.Key, trace.Int64(trace.AttrPart, 1))...)
+			hoo, err := s.client.HeadObjectWithContext(ctx, hoi)
+			span.End(err)
+			if err != nil {
+				return errors.Wrap(err, "s.client.HeadObjectWithContext")
+			}
+			partSize = aws.Int64Value(hoo.ContentLength)
+		}
+		s.verifier = checksum.NewVerifier(s.stored, s.fileSize, partSize)
+	}
+
+	if err := s.verifier.Write(off, p); err != nil {
+		return errors.Wrap(err, "s.verifier.Write")
+	}
+	return nil
+}
@@ -6,14 +6,20 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/checksum"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
@@ -31,17 +37,32 @@ type S3File struct {
 	pipeWriter      *io.PipeWriter
 	uploader        *s3manager.Uploader
 	uploaderOptions []func(*s3manager.Uploader)
+	noOverwrite     bool
+	// closed is set once Close waited for the upload, which returned closeErr
+	closed   bool
+	closeErr error
+	// checksumAlgorithm is sent with every body, see WithChecksumAlgorithm
+	checksumAlgorithm string
+	parts             *checksum.Parts
 
 	// read-related fields
-	readOpened bool
-	fileSize   int64
-	downloader *s3manager.Downloader
+	readOpened     bool
+	fileSize       int64
+	downloader     *s3manager.Downloader
+	verifyChecksum bool
+	// stored is the checksum a sequential scan is verified against
+	stored   checksum.Sum
+	verifier *checksum.Verifier
+	// whole holds the object when it is smaller than wholeThreshold
+	wholeThreshold int64
+	whole          []byte
 
 	lock       sync.RWMutex
 	err        error
 	BucketName string
 	Key        string
 	VersionId  *string
+	ETag       string
 	ACL        string
 }
 
@@ -56,8 +77,30 @@ var (
 	errFailedUpload  = errors.New("Write: failed upload")
 	activeS3Session  *session.Session
 	sessLock         sync.Mutex
+
+	// ErrAborted is returned by writes after Abort
+	ErrAborted = errors.New("Write: aborted")
+	// ErrObjectExists is returned by Close when a writer created with
+	// WithNoOverwrite finds an object at its key
+	ErrObjectExists = errors.New("Close: object already exists")
 )
 
+// WriterOption configures a writer created by NewS3FileWriterWithClient
+type WriterOption func(*S3File)
+
+// ReaderOption configures a reader created by NewS3FileReaderWithClient or
+// NewS3FileReaderVersionedWithClient
+type ReaderOption func(*S3File)
+
+// WithNoOverwrite creates the object only if the key does not exist yet,
+// sending If-None-Match: * with the PutObject or CompleteMultipartUpload
+// request. Close returns ErrObjectExists otherwise.
+func WithNoOverwrite() WriterOption {
+	return func(s *S3File) {
+		s.noOverwrite = true
+	}
+}
+
 // SetActiveSession sets the current session. If this is unset, the functions
 // of this package will implicitly create a new session.Session and use that.
 // This allows you to control what session is used, particularly useful for
@@ -102,6 +145,7 @@ func NewS3FileWriterWithClient(
 	key string,
 	acl string,
 	uploaderOptions []func(*s3manager.Uploader),
+	opts ...WriterOption,
 ) (source.ParquetFile, error) {
 	file := &S3File{
 		ctx:             ctx,
@@ -112,6 +156,12 @@ func NewS3FileWriterWithClient(
 		Key:             key,
 		ACL:             acl,
 	}
+	for _, opt := range opts {
+		opt(file)
+	}
+	if file.checksumAlgorithm != "" && !checksum.Valid(file.checksumAlgorithm) {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", file.checksumAlgorithm)
+	}
 
 	pf, err := file.Create(key)
 	if err != nil {
@@ -131,15 +181,18 @@ func NewS3FileReader(ctx context.Context, bucket string, key string, cfgs ...*aw
 
 // NewS3FileReaderWithClient is the same as NewS3FileReader but allows passing
 // your own S3 client
-func NewS3FileReaderWithClient(ctx context.Context, s3Client s3iface.S3API, bucket string, key string) (source.ParquetFile, error) {
-	pf, err := NewS3FileReaderVersionedWithClient(ctx, s3Client, bucket, key, nil)
+func NewS3FileReaderWithClient(ctx context.Context, s3Client s3iface.S3API, bucket string, key string, opts ...ReaderOption) (source.ParquetFile, error) {
+	pf, err := NewS3FileReaderVersionedWithClient(ctx, s3Client, bucket, key, nil, opts...)
 	if err != nil {
 		return pf, errors.Wrap(err, "NewS3FileReaderVersionedWithClient")
 	}
 	return pf, nil
 }
 
-// Seek tracks the offset for the next Read. Has no effect on Write.
+// Seek tracks the offset for the next Read and returns it. Has no effect on
+// Write. io.SeekEnd offsets are made absolute once the size is known, for
+// objects of unknown size they stay relative to the end and are returned as
+// given.
 func (s *S3File) Seek(offset int64, whence int) (int64, error) {
 	if whence < io.SeekStart || whence > io.SeekEnd {
 		return 0, errors.Wrap(errWhence, "errWhence")
@@ -157,9 +210,11 @@ func (s *S3File) Seek(offset int64, whence int) (int64, error) {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
 		case io.SeekEnd:
-			if offset > -1 || -offset > s.fileSize {
+			if offset > 0 || -offset > s.fileSize {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
+			offset += s.fileSize
+			whence = io.SeekStart
 		}
 	}
 
@@ -173,8 +228,12 @@ func (s *S3File) Read(p []byte) (n int, err error) {
 	if s.fileSize > 0 && s.offset >= s.fileSize {
 		return 0, errors.Wrap(io.EOF, "io.EOF")
 	}
+	if s.whole != nil {
+		return s.readWhole(p)
+	}
 
 	numBytes := len(p)
+	start, whence := s.offset, s.whence
 	getObjRange := s.getBytesRange(numBytes)
 	getObj := &s3.GetObjectInput{
 		Bucket:    aws.String(s.BucketName),
@@ -185,8 +244,11 @@ func (s *S3File) Read(p []byte) (n int, err error) {
 		getObj.Range = aws.String(getObjRange)
 	}
 
+	ctx, span := trace.Start(s.ctx, "s3.GetObject", objectAttributes(getObj.Bucket, getObj.Key, trace.String(trace.AttrRange, getObjRange))...)
 	wab := aws.NewWriteAtBuffer(p)
-	bytesDownloaded, err := s.downloader.DownloadWithContext(s.ctx, wab, getObj)
+	bytesDownloaded, err := s.downloader.DownloadWithContext(ctx, wab, getObj)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, bytesDownloaded))
+	span.End(err)
 	if err != nil {
 		return 0, errors.Wrap(err, "s.downloader.DownloadWithContext")
 	}
@@ -198,9 +260,80 @@ func (s *S3File) Read(p []byte) (n int, err error) {
 		bytesDownloaded = int64(len(p))
 	}
 
+	if s.verifyChecksum && whence != io.SeekEnd {
+		if err = s.verify(start, p[:bytesDownloaded]); err != nil {
+			return int(bytesDownloaded), errors.Wrap(err, "s.verify")
+		}
+	}
 	return int(bytesDownloaded), nil
 }
 
+// ReadAt reads len(p) bytes starting at off with a single ranged GET. It
+// does not use the offset of Seek and is safe for concurrent use, io.EOF is
+// returned when p could not be filled.
+func (s *S3File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.whole != nil {
+		return s.readWholeAt(p, off)
+	}
+
+	end := off + int64(len(p)) - 1
+	if s.fileSize > 0 {
+		if off >= s.fileSize {
+			return 0, errors.Wrap(io.EOF, "io.EOF")
+		}
+		if end >= s.fileSize {
+			end = s.fileSize - 1
+		}
+	}
+	getObj := &s3.GetObjectInput{
+		Bucket:    aws.String(s.BucketName),
+		Key:       aws.String(s.Key),
+		VersionId: s.VersionId,
+		Range:     aws.String(fmt.Sprintf(rangeHeader, off, end)),
+	}
+	if s.ETag != "" {
+		// fail instead of mixing versions, see CacheKey
+		getObj.IfMatch = aws.String(s.ETag)
+	}
+
+	ctx, span := trace.Start(s.ctx, "s3.GetObject", objectAttributes(getObj.Bucket, getObj.Key, trace.String(trace.AttrRange, *getObj.Range))...)
+	wab := aws.NewWriteAtBuffer(p[:end-off+1])
+	bytesDownloaded, err := s.downloader.DownloadWithContext(ctx, wab, getObj)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, bytesDownloaded))
+	span.End(err)
+	if err != nil {
+		return 0, errors.Wrap(err, "s.downloader.DownloadWithContext")
+	}
+
+	n := int(bytesDownloaded)
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// Size returns the object size reported by HeadObject, 0 if unknown
+func (s *S3File) Size() int64 {
+	return s.fileSize
+}
+
+// CacheKey returns the bucket, key and version s was opened on, as used by
+// the diskcache package. The version is empty when the ETag is unknown.
+// ReadAt only reads that version.
+func (s *S3File) CacheKey() (backend, bucket, object, version string) {
+	version = s.ETag
+	if s.VersionId != nil && s.ETag != "" {
+		version = *s.VersionId + "/" + s.ETag
+	}
+	return "s3", s.BucketName, s.Key, version
+}
+
 // Write len(p) bytes from p to the S3 data stream
 func (s *S3File) Write(p []byte) (n int, err error) {
 	s.lock.RLock()
@@ -233,28 +366,114 @@ func (s *S3File) Write(p []byte) (n int, err error) {
 
 // Close signals write completion and cleans up any
 // open streams. Will block until pending uploads are complete.
+// The upload is aborted instead when the context of s was canceled.
+// Close returns ErrAborted, or the error of an upload that had already
+// failed, after Abort. Later calls return the result of the first one.
 func (s *S3File) Close() error {
 	var err error
 
+	s.lock.RLock()
+	closed, closeErr := s.closed, s.closeErr
+	aborted := s.err == ErrAborted
+	s.lock.RUnlock()
+	if closed {
+		return closeErr
+	}
+	if aborted {
+		return ErrAborted
+	}
+
 	if s.pipeWriter != nil {
-		if err = s.pipeWriter.Close(); err != nil {
+		if s.ctx != nil && s.ctx.Err() != nil {
+			s.pipeWriter.CloseWithError(s.ctx.Err())
+		} else if err = s.pipeWriter.Close(); err != nil {
 			return errors.Wrap(err, "s.pipeWriter.Close")
 		}
 	}
 
-	// wait for pending uploads
+	// wait for pending uploads, once
 	if s.writeDone != nil {
+		_, span := trace.Start(s.ctx, "s3.Close", objectAttributes(&s.BucketName, &s.Key)...)
 		err = <-s.writeDone
+		span.End(err)
+		if err != nil {
+			err = errors.Wrap(err, "<-s.writeDone")
+		}
+
+		s.lock.Lock()
+		s.closed = true
+		s.closeErr = err
+		s.lock.Unlock()
 	}
+	return err
+}
 
-	if err != nil {
-		return errors.Wrap(err, "<-s.writeDone")
+// Abort stops the upload without creating the object, the parts of a
+// multipart upload are deleted. It blocks until the uploader returns and
+// has no effect after Close. Close then returns ErrAborted, or the error of
+// an upload that failed before Abort.
+func (s *S3File) Abort() {
+	s.lock.Lock()
+	if s.pipeWriter == nil || s.closed {
+		s.lock.Unlock()
+		return
+	}
+	failed := s.err != nil
+	if !failed {
+		s.err = ErrAborted
+	}
+	s.lock.Unlock()
+	s.pipeWriter.CloseWithError(ErrAborted)
+
+	if s.writeDone != nil {
+		// the upload fails with ErrAborted, unless it failed before
+		_, span := trace.Start(s.ctx, "s3.Abort", objectAttributes(&s.BucketName, &s.Key)...)
+		err := <-s.writeDone
+		span.End(nil)
+		if failed && err != nil {
+			err = errors.Wrap(err, "<-s.writeDone")
+		} else {
+			err = ErrAborted
+		}
+
+		s.lock.Lock()
+		s.closed = true
+		s.closeErr = err
+		s.lock.Unlock()
 	}
-	return nil
 }
 
-// Open creates a new S3 File instance to perform concurrent reads
+// Open creates a new S3 File instance to perform concurrent reads. Instances
+// of the same key read the same version and ETag as s.
 func (s *S3File) Open(name string) (source.ParquetFile, error) {
+	// ColumBuffer passes in an empty string for name
+	if len(name) == 0 {
+		name = s.Key
+	}
+
+	// files opened from a writer have no downloader yet
+	downloader := s.downloader
+	if downloader == nil {
+		downloader = s3manager.NewDownloaderWithClient(s.client)
+	}
+
+	if name != s.Key {
+		// another object, with its own size and ETag
+		pf := &S3File{
+			ctx:            s.ctx,
+			client:         s.client,
+			downloader:     downloader,
+			BucketName:     s.BucketName,
+			Key:            name,
+			verifyChecksum: s.verifyChecksum,
+			wholeThreshold: s.wholeThreshold,
+		}
+		if err := pf.openRead(); err != nil {
+			return nil, errors.Wrap(err, "pf.openRead")
+		}
+		return pf, nil
+	}
+
 	s.lock.RLock()
 	readOpened := s.readOpened
 	s.lock.RUnlock()
@@ -264,22 +483,22 @@ func (s *S3File) Open(name string) (source.ParquetFile, error) {
 		}
 	}
 
-	// ColumBuffer passes in an empty string for name
-	if len(name) == 0 {
-		name = s.Key
-	}
-
 	// create a new instance
 	pf := &S3File{
-		ctx:        s.ctx,
-		client:     s.client,
-		downloader: s.downloader,
-		BucketName: s.BucketName,
-		Key:        name,
-		VersionId:  s.VersionId,
-		readOpened: s.readOpened,
-		fileSize:   s.fileSize,
-		offset:     0,
+		ctx:            s.ctx,
+		client:         s.client,
+		downloader:     downloader,
+		BucketName:     s.BucketName,
+		Key:            name,
+		VersionId:      s.VersionId,
+		ETag:           s.ETag,
+		readOpened:     s.readOpened,
+		fileSize:       s.fileSize,
+		stored:         s.stored,
+		verifyChecksum: s.verifyChecksum,
+		wholeThreshold: s.wholeThreshold,
+		whole:          s.whole,
+		offset:         0,
 	}
 	return pf, nil
 }
@@ -287,13 +506,15 @@ func (s *S3File) Open(name string) (source.ParquetFile, error) {
 // Create creates a new S3 File instance to perform writes
 func (s *S3File) Create(key string) (source.ParquetFile, error) {
 	pf := &S3File{
-		ctx:             s.ctx,
-		client:          s.client,
-		uploaderOptions: s.uploaderOptions,
-		BucketName:      s.BucketName,
-		ACL:             s.ACL,
-		Key:             key,
-		writeDone:       make(chan error),
+		ctx:               s.ctx,
+		client:            s.client,
+		uploaderOptions:   s.uploaderOptions,
+		noOverwrite:       s.noOverwrite,
+		BucketName:        s.BucketName,
+		ACL:               s.ACL,
+		checksumAlgorithm: s.checksumAlgorithm,
+		Key:               key,
+		writeDone:         make(chan error),
 	}
 	pf.openWrite()
 	return pf, nil
@@ -303,8 +524,18 @@ func (s *S3File) Create(key string) (source.ParquetFile, error) {
 // Calling Close signals write completion.
 func (s *S3File) openWrite() {
 	pr, pw := io.Pipe()
-	uploader := s3manager.NewUploaderWithClient(s.client, s.uploaderOptions...)
+	client := s.client
+	if s.noOverwrite {
+		client = noOverwriteClient{client}
+	}
+	var parts *checksum.Parts
+	if s.checksumAlgorithm != "" {
+		parts = &checksum.Parts{}
+		client = checksumClient{S3API: client, parts: parts, algorithm: s.checksumAlgorithm}
+	}
+	uploader := s3manager.NewUploaderWithClient(abortClient{tracedClient{client}}, s.uploaderOptions...)
 	s.lock.Lock()
+	s.parts = parts
 	s.pipeReader = pr
 	s.pipeWriter = pw
 	s.writeOpened = true
@@ -318,15 +549,26 @@ func (s *S3File) openWrite() {
 		Body:   s.pipeReader,
 	}
 
+	stop := make(chan struct{})
+	go s.abortOnCancel(pw, stop)
+
 	go func(uploader *s3manager.Uploader, params *s3manager.UploadInput, done chan error) {
 		defer close(done)
 
 		// upload data and signal done when complete
 		_, err := uploader.UploadWithContext(s.ctx, params)
-		if err != nil {
+		close(stop)
+		if err != nil && s.noOverwrite && preconditionFailed(err) {
+			err = errors.Wrapf(ErrObjectExists, "uploader.UploadWithContext: %v", err)
+		} else if err != nil {
 			err = errors.Wrap(err, "uploader.UploadWithContext")
+		}
+		if err != nil {
 			s.lock.Lock()
-			s.err = err
+			// keep ErrAborted when the upload failed because of Abort
+			if s.err == nil {
+				s.err = err
+			}
 			s.lock.Unlock()
 
 			if s.writeOpened {
@@ -338,6 +580,63 @@ func (s *S3File) openWrite() {
 	}(s.uploader, uploadParams, s.writeDone)
 }
 
+// noOverwriteClient makes the requests creating the object conditional on
+// the key not existing
+type noOverwriteClient struct {
+	s3iface.S3API
+}
+
+var ifNoneMatch = request.WithSetRequestHeaders(map[string]string{"If-None-Match": "*"})
+
+// PutObjectRequest is used by the uploader for single part uploads
+func (c noOverwriteClient) PutObjectRequest(in *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	req, out := c.S3API.PutObjectRequest(in)
+	req.ApplyOptions(ifNoneMatch)
+	return req, out
+}
+
+func (c noOverwriteClient) CompleteMultipartUploadWithContext(ctx aws.Context, in *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	return c.S3API.CompleteMultipartUploadWithContext(ctx, in, append(opts, ifNoneMatch)...)
+}
+
+// preconditionFailed reports whether err, or an error it was caused by, is
+// a failed If-None-Match
+func preconditionFailed(err error) bool {
+	for err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == "PreconditionFailed" {
+				return true
+			}
+			err = aerr.OrigErr()
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
+
+// abortClient aborts multipart uploads with a context that is never
+// canceled. The uploader aborts with its own context, which may be the
+// canceled context that made the upload fail.
+type abortClient struct {
+	s3iface.S3API
+}
+
+func (c abortClient) AbortMultipartUploadWithContext(ctx aws.Context, in *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	return c.S3API.AbortMultipartUploadWithContext(sourceutil.Detach(ctx), in, opts...)
+}
+
+// abortOnCancel ends the body of the upload with the error of the context
+// once it is canceled, so that the uploader aborts the upload instead of
+// waiting for more data
+func (s *S3File) abortOnCancel(pw *io.PipeWriter, stop chan struct{}) {
+	select {
+	case <-s.ctx.Done():
+		pw.CloseWithError(s.ctx.Err())
+	case <-stop:
+	}
+}
+
 // openRead verifies the requested file is accessible and
 // tracks the file size
 func (s *S3File) openRead() error {
@@ -347,18 +646,45 @@ func (s *S3File) openRead() error {
 		VersionId: s.VersionId,
 	}
 
-	hoo, err := s.client.HeadObjectWithContext(s.ctx, hoi)
+	var (
+		opts   []request.Option
+		header http.Header
+	)
+	if s.verifyChecksum {
+		opts = append(opts, checksumMode, responseHeader(&header))
+	}
+
+	ctx, span := trace.Start(s.ctx, "s3.HeadObject", objectAttributes(hoi.Bucket, hoi.Key)...)
+	hoo, err := s.client.HeadObjectWithContext(ctx, hoi, opts...)
+	span.End(err)
 	if err != nil {
 		return errors.Wrap(err, "s.client.HeadObjectWithContext")
 	}
 
+	var stored checksum.Sum
+	if s.verifyChecksum {
+		if stored, err = storedChecksum(hoo, header); err != nil {
+			return errors.Wrap(err, "storedChecksum")
+		}
+	}
+
 	s.lock.Lock()
 	s.readOpened = true
 	if hoo.ContentLength != nil {
 		s.fileSize = *hoo.ContentLength
 	}
+	if hoo.ETag != nil {
+		s.ETag = *hoo.ETag
+	}
+	s.stored = stored
 	s.lock.Unlock()
 
+	// small objects are read with a single request
+	if s.fileSize > 0 && s.fileSize <= s.wholeThreshold {
+		if err = s.download(); err != nil {
+			return errors.Wrap(err, "s.download")
+		}
+	}
 	return nil
 }
 
@@ -423,7 +749,7 @@ func NewS3FileReaderVersioned(ctx context.Context, bucket string, key string, ve
 
 // NewS3FileReaderVersionedWithClient is the same as NewS3FileReaderVersioned but allows passing
 // your own S3 client
-func NewS3FileReaderVersionedWithClient(ctx context.Context, s3Client s3iface.S3API, bucket string, key string, version *string) (source.ParquetFile, error) {
+func NewS3FileReaderVersionedWithClient(ctx context.Context, s3Client s3iface.S3API, bucket string, key string, version *string, opts ...ReaderOption) (source.ParquetFile, error) {
 	s3Downloader := s3manager.NewDownloaderWithClient(s3Client)
 
 	file := &S3File{
@@ -434,6 +760,9 @@ func NewS3FileReaderVersionedWithClient(ctx context.Context, s3Client s3iface.S3
 		Key:        key,
 		VersionId:  version,
 	}
+	for _, opt := range opts {
+		opt(file)
+	}
 
 	pf, err := file.Open(key)
 	if err != nil {
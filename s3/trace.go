@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+// objectAttributes returns the span attributes identifying an object
+func objectAttributes(bucket *string, key *string, attrs ...trace.Attribute) []trace.Attribute {
+	return append([]trace.Attribute{
+		trace.String(trace.AttrBackend, "s3"),
+		trace.String(trace.AttrBucket, aws.StringValue(bucket)),
+		trace.String(trace.AttrKey, aws.StringValue(key)),
+	}, attrs...)
+}
+
+// tracedClient starts a span around each request the uploader makes, so
+// that every part of a multipart upload is traced
+type tracedClient struct {
+	s3iface.S3API
+}
+
+// PutObjectRequest is used by the uploader for single part uploads, the
+// span covers the sending of the request
+func (c tracedClient) PutObjectRequest(in *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	req, out := c.S3API.PutObjectRequest(in)
+	var span trace.Span
+	req.Handlers.Build.PushFront(func(r *request.Request) {
+		var ctx aws.Context
+		ctx, span = trace.Start(r.Context(), "s3.PutObject", objectAttributes(in.Bucket, in.Key)...)
+		r.SetContext(ctx)
+	})
+	req.Handlers.Complete.PushBack(func(r *request.Request) {
+		if span != nil {
+			span.End(r.Error)
+		}
+	})
+	return req, out
+}
+
+func (c tracedClient) UploadPartWithContext(ctx aws.Context, in *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.UploadPart", objectAttributes(in.Bucket, in.Key, trace.Int64(trace.AttrPart, aws.Int64Value(in.PartNumber)))...)
+	if in.ContentLength != nil {
+		span.SetAttributes(trace.Int64(trace.AttrBytes, *in.ContentLength))
+	}
+	out, err := c.S3API.UploadPartWithContext(ctx, in, opts...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) CreateMultipartUploadWithContext(ctx aws.Context, in *s3.CreateMultipartUploadInput, opts ...request.Option) (*s3.CreateMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.CreateMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.CreateMultipartUploadWithContext(ctx, in, opts...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) CompleteMultipartUploadWithContext(ctx aws.Context, in *s3.CompleteMultipartUploadInput, opts ...request.Option) (*s3.CompleteMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.CompleteMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.CompleteMultipartUploadWithContext(ctx, in, opts...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) AbortMultipartUploadWithContext(ctx aws.Context, in *s3.AbortMultipartUploadInput, opts ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.AbortMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.AbortMultipartUploadWithContext(ctx, in, opts...)
+	span.End(err)
+	return out, err
+}
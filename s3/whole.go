@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+// WithWholeObjectThreshold makes a reader download objects of at most size
+// bytes with a single GetObject when it is opened, every handle returned by
+// Open("") then reads from that copy instead of sending ranged requests
+func WithWholeObjectThreshold(size int64) ReaderOption {
+	return func(s *S3File) {
+		s.wholeThreshold = size
+	}
+}
+
+// download reads the whole object into memory
+func (s *S3File) download() error {
+	getObj := &s3.GetObjectInput{
+		Bucket:    aws.String(s.BucketName),
+		Key:       aws.String(s.Key),
+		VersionId: s.VersionId,
+	}
+	if s.ETag != "" {
+		getObj.IfMatch = aws.String(s.ETag)
+	}
+	ctx, span := trace.Start(s.ctx, "s3.GetObject", objectAttributes(getObj.Bucket, getObj.Key)...)
+	goo, err := s.client.GetObjectWithContext(ctx, getObj)
+	if err != nil {
+		span.End(err)
+		return errors.Wrap(err, "s.client.GetObjectWithContext")
+	}
+	defer goo.Body.Close()
+
+	whole := make([]byte, s.fileSize)
+	n, err := io.ReadFull(goo.Body, whole)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, int64(n)))
+	span.End(err)
+	if err != nil {
+		return errors.Wrap(err, "io.ReadFull")
+	}
+
+	if s.verifyChecksum {
+		if err = s.verify(0, whole); err != nil {
+			return errors.Wrap(err, "s.verify")
+		}
+	}
+	s.whole = whole
+	return nil
+}
+
+// readWhole is Read for objects held in memory
+func (s *S3File) readWhole(p []byte) (int, error) {
+	begin := s.offset
+	if begin >= s.fileSize {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+
+	n := copy(p, s.whole[begin:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+// readWholeAt is ReadAt for objects held in memory
+func (s *S3File) readWholeAt(p []byte, off int64) (int, error) {
+	if off >= s.fileSize {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+	n := copy(p, s.whole[off:])
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
@@ -0,0 +1,936 @@
+// Package s3test provides an in-process S3 compatible server for tests and
+// examples. It implements the subset of the S3 API used by the s3 and s3v2
+// packages: GetObject (with Range, partNumber, versionId and If-Match),
+// HeadObject, PutObject, DeleteObject, multipart uploads and ListObjectsV2.
+// PutObject and CompleteMultipartUpload honour If-None-Match: * and bodies
+// are checked against their Content-MD5 and x-amz-checksum-crc32c or
+// x-amz-checksum-sha256 header. Multipart uploads created with an
+// x-amz-checksum-algorithm require the checksum of every part, the object
+// gets the checksum of their checksums. Objects written with
+// x-amz-server-side-encryption: aws:kms get an ETag that is not their MD5,
+// as on S3. Every write creates a new version of the object. Requests are
+// not authenticated.
+//
+// Run checks the behaviour the s3 and s3v2 files share against a Server.
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	s3v1 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+const (
+	region     = "us-east-1"
+	xmlns      = "http://s3.amazonaws.com/doc/2006-03-01/"
+	timeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// Request is a request received by the Server
+type Request struct {
+	Method string
+	Bucket string
+	Key    string
+	Query  string
+	Range  string
+	Header http.Header
+}
+
+type object struct {
+	data      []byte
+	parts     []int64
+	etag      string
+	version   string
+	modified  time.Time
+	algorithm string
+	checksum  string
+	// encryption is the x-amz-server-side-encryption of the object
+	encryption string
+}
+
+type upload struct {
+	bucket     string
+	key        string
+	algorithm  string
+	encryption string
+	parts      map[int]*object
+}
+
+// Server is a fake S3 endpoint backed by memory
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	buckets  map[string]map[string]*object
+	versions map[string]*object
+	uploads  map[string]*upload
+	requests []Request
+	uploadID int
+	version  int
+	corrupt  bool
+	denied   bool
+}
+
+// NewServer starts a Server. Buckets must be created with CreateBucket
+// before they are used. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		buckets:  map[string]map[string]*object{},
+		versions: map[string]*object{},
+		uploads:  map[string]*upload{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// ClientV1 returns an aws-sdk-go client using path style requests against s
+func (s *Server) ClientV1() s3iface.S3API {
+	sess := session.Must(session.NewSession(&awsv1.Config{
+		Credentials:      credentials.NewStaticCredentials("s3test", "s3test", ""),
+		Endpoint:         awsv1.String(s.URL),
+		Region:           awsv1.String(region),
+		S3ForcePathStyle: awsv1.Bool(true),
+		MaxRetries:       awsv1.Int(0),
+	}))
+	return s3v1.New(sess)
+}
+
+// ClientV2 returns an aws-sdk-go-v2 client using path style requests against s
+func (s *Server) ClientV2() *s3v2.Client {
+	return s3v2.New(s3v2.Options{
+		Credentials: awsv2.CredentialsProviderFunc(func(context.Context) (awsv2.Credentials, error) {
+			return awsv2.Credentials{AccessKeyID: "s3test", SecretAccessKey: "s3test", Source: "s3test"}, nil
+		}),
+		EndpointResolver: s3v2.EndpointResolverFromURL(s.URL),
+		Region:           region,
+		UsePathStyle:     true,
+	})
+}
+
+// CreateBucket creates an empty bucket, existing buckets are left untouched
+func (s *Server) CreateBucket(bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = map[string]*object{}
+	}
+}
+
+// PutObject stores data at bucket/key, creating the bucket if needed
+func (s *Server) PutObject(bucket string, key string, data []byte) {
+	s.CreateBucket(bucket)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, key, newObject(data))
+}
+
+// PutEncryptedObject stores data as bucket/key encrypted with KMS, with the
+// checksum of algorithm, CRC32C or SHA256, unless it is empty
+func (s *Server) PutEncryptedObject(bucket string, key string, data []byte, algorithm string) {
+	obj := newObject(data)
+	obj.encrypt("aws:kms")
+	if algorithm != "" {
+		h := newChecksum(algorithm)
+		h.Write(data)
+		obj.algorithm, obj.checksum = algorithm, base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+
+	s.CreateBucket(bucket)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(bucket, key, obj)
+}
+
+// Object returns the content of bucket/key
+func (s *Server) Object(bucket string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// Version returns the version ID of the current version of bucket/key
+func (s *Server) Version(bucket string, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return "", false
+	}
+	return obj.version, true
+}
+
+// Checksum returns the algorithm and base64 encoded value of the checksum
+// stored with bucket/key, empty when it was uploaded without one
+func (s *Server) Checksum(bucket string, key string) (string, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return "", "", false
+	}
+	return obj.algorithm, obj.checksum, true
+}
+
+// ETag returns the ETag of the current version of bucket/key, quoted as in
+// the ETag header
+func (s *Server) ETag(bucket string, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return "", false
+	}
+	return obj.etag, true
+}
+
+// CorruptReads makes GetObject return bodies with their first byte changed
+// until it is called with false, as a bit flip on the network would
+func (s *Server) CorruptReads(corrupt bool) {
+	s.mu.Lock()
+	s.corrupt = corrupt
+	s.mu.Unlock()
+}
+
+// DenyWrites makes PutObject and the multipart upload requests fail with
+// AccessDenied until it is called with false
+func (s *Server) DenyWrites(deny bool) {
+	s.mu.Lock()
+	s.denied = deny
+	s.mu.Unlock()
+}
+
+// Uploads returns the number of multipart uploads that have been neither
+// completed nor aborted
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// Requests returns every request received since the last ResetRequests
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Gets returns the GET requests received since the last ResetRequests, the
+// reads of the object data
+func (s *Server) Gets() []Request {
+	var gets []Request
+	for _, r := range s.Requests() {
+		if r.Method == http.MethodGet {
+			gets = append(gets, r)
+		}
+	}
+	return gets
+}
+
+// ResetRequests clears the request log
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+func newObject(data []byte) *object {
+	sum := md5.Sum(data)
+	return &object{
+		data:     data,
+		etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+		modified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// store makes obj the current version of bucket/key, s.mu must be held
+func (s *Server) store(bucket string, key string, obj *object) {
+	s.version++
+	obj.version = strconv.Itoa(s.version)
+	s.buckets[bucket][key] = obj
+	s.versions[versionKey(bucket, key, obj.version)] = obj
+}
+
+// encrypt records the x-amz-server-side-encryption of obj. The ETag of an
+// object encrypted with KMS is not the MD5 of its data.
+func (obj *object) encrypt(encryption string) {
+	obj.encryption = encryption
+	if obj.encryption == "aws:kms" {
+		sum := md5.Sum([]byte(obj.etag + obj.encryption))
+		obj.etag = `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+}
+
+func versionKey(bucket string, key string, version string) string {
+	return bucket + "/" + key + "?versionId=" + version
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	writeXML(w, s3Error{Code: code, Message: message, Resource: r.URL.Path, RequestID: "s3test"})
+}
+
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+}
+
+// checkContentMD5 reports whether data matches the Content-MD5 header of r,
+// if any
+func checkContentMD5(r *http.Request, data []byte) bool {
+	header := r.Header.Get("Content-MD5")
+	if header == "" {
+		return true
+	}
+	sum := md5.Sum(data)
+	return header == base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checksumAlgorithms are the algorithms of the x-amz-checksum headers
+var checksumAlgorithms = []string{"CRC32C", "SHA256"}
+
+func checksumHeader(algorithm string) string {
+	return http.CanonicalHeaderKey("x-amz-checksum-" + strings.ToLower(algorithm))
+}
+
+func newChecksum(algorithm string) hash.Hash {
+	if algorithm == "CRC32C" {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return sha256.New()
+}
+
+// checkChecksum returns the algorithm and value of the x-amz-checksum header
+// of r, if any, and reports whether it matches data
+func checkChecksum(r *http.Request, data []byte) (string, string, bool) {
+	for _, algorithm := range checksumAlgorithms {
+		header := r.Header.Get(checksumHeader(algorithm))
+		if header == "" {
+			continue
+		}
+		h := newChecksum(algorithm)
+		h.Write(data)
+		return algorithm, header, header == base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+	return "", "", true
+}
+
+func writeBadChecksum(w http.ResponseWriter, r *http.Request, algorithm string) {
+	writeError(w, r, http.StatusBadRequest, "BadDigest", fmt.Sprintf("The %s you specified did not match the calculated checksum.", algorithm))
+}
+
+func writeXML(w io.Writer, v interface{}) {
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := r.URL.Path, ""
+	bucket = strings.TrimPrefix(bucket, "/")
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Bucket: bucket,
+		Key:    key,
+		Query:  r.URL.RawQuery,
+		Range:  r.Header.Get("Range"),
+		Header: r.Header.Clone(),
+	})
+	denied := s.denied
+	s.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case denied && key != "" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		writeError(w, r, http.StatusForbidden, "AccessDenied", "Access Denied")
+	case bucket == "":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "ListBuckets is not supported")
+	case key == "" && r.Method == http.MethodPut:
+		s.CreateBucket(bucket)
+		w.WriteHeader(http.StatusOK)
+	case key == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.listObjects(w, r, bucket)
+	case key == "":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "unsupported bucket operation")
+	case r.Method == http.MethodPost && hasQuery(query, "uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && hasQuery(query, "uploadId"):
+		s.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && hasQuery(query, "uploadId"):
+		s.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodDelete && hasQuery(query, "uploadId"):
+		s.abortMultipartUpload(w, r, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "unsupported object operation")
+	}
+}
+
+func hasQuery(query map[string][]string, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+// readBody returns the request payload, decoding aws-chunked bodies sent
+// when the payload is signed in streaming mode
+func readBody(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") &&
+		!strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	var (
+		body bytes.Buffer
+		br   = bufio.NewReader(r.Body)
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "chunk header")
+		}
+		sizeHex := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "chunk size")
+		}
+		if size == 0 {
+			return body.Bytes(), nil
+		}
+		if _, err = io.CopyN(&body, br, size); err != nil {
+			return nil, errors.Wrap(err, "chunk data")
+		}
+		if _, err = br.ReadString('\n'); err != nil {
+			return nil, errors.Wrap(err, "chunk trailer")
+		}
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkContentMD5(r, data) {
+		writeError(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		return
+	}
+	algorithm, sum, ok := checkChecksum(r, data)
+	if !ok {
+		writeBadChecksum(w, r, algorithm)
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if _, ok := s.buckets[bucket][key]; ok && r.Header.Get("If-None-Match") == "*" {
+		s.mu.Unlock()
+		writePreconditionFailed(w, r)
+		return
+	}
+	obj := newObject(data)
+	obj.algorithm, obj.checksum = algorithm, sum
+	obj.encrypt(r.Header.Get("X-Amz-Server-Side-Encryption"))
+	s.store(bucket, key, obj)
+	s.mu.Unlock()
+
+	if algorithm != "" {
+		w.Header().Set(checksumHeader(algorithm), sum)
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("x-amz-version-id", obj.version)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	versionID := r.URL.Query().Get("versionId")
+	s.mu.Lock()
+	obj, ok := s.buckets[bucket][key]
+	if versionID != "" {
+		obj, ok = s.versions[versionKey(bucket, key, versionID)]
+	}
+	corrupt := s.corrupt
+	s.mu.Unlock()
+	if !ok && versionID != "" {
+		writeError(w, r, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
+		return
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != obj.etag {
+		writePreconditionFailed(w, r)
+		return
+	}
+
+	size := int64(len(obj.data))
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("x-amz-version-id", obj.version)
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", "binary/octet-stream")
+	if obj.algorithm != "" && r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+		w.Header().Set(checksumHeader(obj.algorithm), obj.checksum)
+	}
+	if obj.encryption != "" {
+		w.Header().Set("X-Amz-Server-Side-Encryption", obj.encryption)
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if partNumber := r.URL.Query().Get("partNumber"); partNumber != "" {
+		start, end, count, ok := obj.partRange(partNumber)
+		if !ok {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "The requested partnumber is not satisfiable")
+			return
+		}
+		w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(count))
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	if rangeHeader == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(body(obj.data, corrupt))
+		}
+		return
+	}
+
+	start, end, ok := parseRange(rangeHeader, size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method != http.MethodHead {
+		w.Write(body(obj.data[start:end+1], corrupt))
+	}
+}
+
+// partRange returns the inclusive byte range of a part of obj and its number
+// of parts, an object that was not uploaded in parts has a single one
+// body returns data, with its first byte changed when corrupt is set
+func body(data []byte, corrupt bool) []byte {
+	if !corrupt || len(data) == 0 {
+		return data
+	}
+	data = append([]byte(nil), data...)
+	data[0]++
+	return data
+}
+
+func (obj *object) partRange(partNumber string) (start int64, end int64, count int, ok bool) {
+	number, err := strconv.Atoi(partNumber)
+	parts := obj.parts
+	if parts == nil {
+		parts = []int64{int64(len(obj.data))}
+	}
+	if err != nil || number < 1 || number > len(parts) || parts[number-1] == 0 {
+		return 0, 0, 0, false
+	}
+	for _, size := range parts[:number-1] {
+		start += size
+	}
+	return start, start + parts[number-1] - 1, len(parts), true
+}
+
+// parseRange resolves a single "bytes=" range against size, the returned
+// end is inclusive
+func parseRange(header string, size int64) (start int64, end int64, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	var err error
+	if parts[0] == "" {
+		// suffix range: bytes=-n
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mu.Lock()
+	delete(s.buckets[bucket], key)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mu.Lock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	algorithm := r.Header.Get("X-Amz-Checksum-Algorithm")
+	if algorithm != "" && algorithm != "CRC32C" && algorithm != "SHA256" {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "unsupported checksum algorithm "+algorithm)
+		return
+	}
+	s.uploadID++
+	id := strconv.Itoa(s.uploadID)
+	s.uploads[id] = &upload{
+		bucket:     bucket,
+		key:        key,
+		algorithm:  algorithm,
+		encryption: r.Header.Get("X-Amz-Server-Side-Encryption"),
+		parts:      map[int]*object{},
+	}
+	s.mu.Unlock()
+
+	if algorithm != "" {
+		w.Header().Set("X-Amz-Checksum-Algorithm", algorithm)
+	}
+	writeXML(w, initiateMultipartUploadResult{Xmlns: xmlns, Bucket: bucket, Key: key, UploadID: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, id string, partNumber string) {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkContentMD5(r, data) {
+		writeError(w, r, http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		return
+	}
+
+	algorithm, sum, ok := checkChecksum(r, data)
+	if !ok {
+		writeBadChecksum(w, r, algorithm)
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	if algorithm != u.algorithm {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("the upload was created with checksum algorithm %q, the part was sent with %q", u.algorithm, algorithm))
+		return
+	}
+	part := newObject(data)
+	part.algorithm, part.checksum = algorithm, sum
+	u.parts[number] = part
+	s.mu.Unlock()
+
+	if algorithm != "" {
+		w.Header().Set(checksumHeader(algorithm), sum)
+	}
+	w.Header().Set("ETag", part.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber     int    `xml:"PartNumber"`
+		ETag           string `xml:"ETag"`
+		ChecksumCRC32C string `xml:"ChecksumCRC32C"`
+		ChecksumSHA256 string `xml:"ChecksumSHA256"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	Location       string   `xml:"Location"`
+	Bucket         string   `xml:"Bucket"`
+	Key            string   `xml:"Key"`
+	ETag           string   `xml:"ETag"`
+	ChecksumCRC32C string   `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, id string) {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	if _, ok := s.buckets[bucket][key]; ok && r.Header.Get("If-None-Match") == "*" {
+		writePreconditionFailed(w, r)
+		return
+	}
+
+	var (
+		data     []byte
+		parts    []int64
+		sums     []byte
+		checksum hash.Hash
+		index    = -1
+	)
+	if u.algorithm != "" {
+		checksum = newChecksum(u.algorithm)
+	}
+	for _, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || part.etag != p.ETag {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", p.PartNumber))
+			return
+		}
+		if checksum != nil {
+			partChecksum := p.ChecksumCRC32C
+			if u.algorithm == "SHA256" {
+				partChecksum = p.ChecksumSHA256
+			}
+			if partChecksum != part.checksum {
+				writeError(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was listed with checksum %q instead of %q", p.PartNumber, partChecksum, part.checksum))
+				return
+			}
+			raw, _ := base64.StdEncoding.DecodeString(part.checksum)
+			checksum.Write(raw)
+		}
+		if p.PartNumber <= index {
+			writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+			return
+		}
+		index = p.PartNumber
+		data = append(data, part.data...)
+		parts = append(parts, int64(len(part.data)))
+		sum := md5.Sum(part.data)
+		sums = append(sums, sum[:]...)
+	}
+
+	obj := newObject(data)
+	obj.parts = parts
+	sum := md5.Sum(sums)
+	obj.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	if u.encryption == "aws:kms" {
+		sum = md5.Sum([]byte(obj.etag + u.encryption))
+		obj.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts))
+	}
+	obj.encryption = u.encryption
+	result := completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: s.URL + r.URL.Path,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     obj.etag,
+	}
+	if checksum != nil {
+		obj.algorithm = u.algorithm
+		obj.checksum = fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(checksum.Sum(nil)), len(req.Parts))
+		if u.algorithm == "CRC32C" {
+			result.ChecksumCRC32C = obj.checksum
+		} else {
+			result.ChecksumSHA256 = obj.checksum
+		}
+	}
+	s.store(bucket, key, obj)
+	delete(s.uploads, id)
+
+	w.Header().Set("x-amz-version-id", obj.version)
+	writeXML(w, result)
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	_, ok := s.uploads[id]
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name      `xml:"ListBucketResult"`
+	Xmlns                 string        `xml:"xmlns,attr"`
+	Name                  string        `xml:"Name"`
+	Prefix                string        `xml:"Prefix"`
+	KeyCount              int           `xml:"KeyCount"`
+	MaxKeys               int           `xml:"MaxKeys"`
+	IsTruncated           bool          `xml:"IsTruncated"`
+	ContinuationToken     string        `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string        `xml:"NextContinuationToken,omitempty"`
+	Contents              []listContent `xml:"Contents"`
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	token := query.Get("continuation-token")
+	startAfter := query.Get("start-after")
+	if token != "" {
+		startAfter = token
+	}
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = n
+	}
+
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Xmlns:             xmlns,
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+	}
+	if maxKeys == 0 {
+		// S3 reports whether there are keys but returns none and no token
+		result.IsTruncated = len(keys) > 0
+		keys = nil
+	}
+	for _, key := range keys {
+		if len(result.Contents) == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = result.Contents[len(result.Contents)-1].Key
+			break
+		}
+		obj := objects[key]
+		result.Contents = append(result.Contents, listContent{
+			Key:          key,
+			LastModified: obj.modified.Format(timeFormat),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+	s.mu.Unlock()
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	writeXML(w, result)
+}
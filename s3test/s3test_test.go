@@ -0,0 +1,267 @@
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	s3v1 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const bucket = "test-bucket"
+
+func TestV1RangeAndHead(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	data := testData(100)
+	s.PutObject(bucket, "a/b.parquet", data)
+
+	client := s.ClientV1()
+	hoo, err := client.HeadObject(&s3v1.HeadObjectInput{Bucket: awsv1.String(bucket), Key: awsv1.String("a/b.parquet")})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if *hoo.ContentLength != int64(len(data)) {
+		t.Errorf("expected content length %d but got %d", len(data), *hoo.ContentLength)
+	}
+
+	testcases := []struct {
+		rangeHeader string
+		expected    []byte
+	}{
+		{"bytes=10-19", data[10:20]},
+		{"bytes=90-", data[90:]},
+		{"bytes=-8", data[92:]},
+		{"bytes=95-200", data[95:]},
+	}
+	for _, tc := range testcases {
+		goo, err := client.GetObject(&s3v1.GetObjectInput{
+			Bucket: awsv1.String(bucket),
+			Key:    awsv1.String("a/b.parquet"),
+			Range:  awsv1.String(tc.rangeHeader),
+		})
+		if err != nil {
+			t.Fatalf("%s: expected error to be nil but got %q", tc.rangeHeader, err.Error())
+		}
+		body, _ := ioutil.ReadAll(goo.Body)
+		goo.Body.Close()
+		if !bytes.Equal(body, tc.expected) {
+			t.Errorf("%s: expected %v but got %v", tc.rangeHeader, tc.expected, body)
+		}
+	}
+
+	_, err = client.GetObject(&s3v1.GetObjectInput{Bucket: awsv1.String(bucket), Key: awsv1.String("missing")})
+	if err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestV1MultipartUpload(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.CreateBucket(bucket)
+	data := testData(11 * 1024 * 1024)
+
+	uploader := s3manager.NewUploaderWithClient(s.ClientV1())
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: awsv1.String(bucket),
+		Key:    awsv1.String("multi"),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	stored, ok := s.Object(bucket, "multi")
+	if !ok || !bytes.Equal(stored, data) {
+		t.Errorf("expected multipart object to match the uploaded data")
+	}
+	if n := s.Uploads(); n != 0 {
+		t.Errorf("expected no pending uploads but got %d", n)
+	}
+}
+
+func TestV1ListZeroMaxKeys(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.PutObject(bucket, "a", testData(10))
+
+	loo, err := s.ClientV1().ListObjectsV2(&s3v1.ListObjectsV2Input{
+		Bucket:  awsv1.String(bucket),
+		MaxKeys: awsv1.Int64(0),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if len(loo.Contents) != 0 || !*loo.IsTruncated || loo.NextContinuationToken != nil {
+		t.Fatalf("expected a truncated empty page without a token but got %+v", loo)
+	}
+}
+
+func TestV2MultipartUploadAndList(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.CreateBucket(bucket)
+	data := testData(11 * 1024 * 1024)
+	client := s.ClientV2()
+	ctx := context.Background()
+
+	uploader := manager.NewUploader(client)
+	_, err := uploader.Upload(ctx, &s3v2.PutObjectInput{
+		Bucket: awsv2.String(bucket),
+		Key:    awsv2.String("dir/multi"),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	stored, ok := s.Object(bucket, "dir/multi")
+	if !ok || !bytes.Equal(stored, data) {
+		t.Errorf("expected multipart object to match the uploaded data")
+	}
+
+	s.PutObject(bucket, "dir/small", data[:10])
+	s.PutObject(bucket, "other", data[:10])
+	loo, err := client.ListObjectsV2(ctx, &s3v2.ListObjectsV2Input{
+		Bucket:  awsv2.String(bucket),
+		Prefix:  awsv2.String("dir/"),
+		MaxKeys: 1,
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if len(loo.Contents) != 1 || *loo.Contents[0].Key != "dir/multi" || !loo.IsTruncated {
+		t.Fatalf("expected first page to hold dir/multi but got %+v", loo.Contents)
+	}
+	loo, err = client.ListObjectsV2(ctx, &s3v2.ListObjectsV2Input{
+		Bucket:            awsv2.String(bucket),
+		Prefix:            awsv2.String("dir/"),
+		ContinuationToken: loo.NextContinuationToken,
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if len(loo.Contents) != 1 || *loo.Contents[0].Key != "dir/small" || loo.Contents[0].Size != 10 {
+		t.Fatalf("expected second page to hold dir/small but got %+v", loo.Contents)
+	}
+}
+
+func TestV2AbortMultipartUpload(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.CreateBucket(bucket)
+	client := s.ClientV2()
+	ctx := context.Background()
+
+	cmuo, err := client.CreateMultipartUpload(ctx, &s3v2.CreateMultipartUploadInput{
+		Bucket: awsv2.String(bucket),
+		Key:    awsv2.String("aborted"),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	_, err = client.UploadPart(ctx, &s3v2.UploadPartInput{
+		Bucket:     awsv2.String(bucket),
+		Key:        awsv2.String("aborted"),
+		UploadId:   cmuo.UploadId,
+		PartNumber: 1,
+		Body:       bytes.NewReader(testData(10)),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n := s.Uploads(); n != 1 {
+		t.Errorf("expected 1 pending upload but got %d", n)
+	}
+
+	_, err = client.AbortMultipartUpload(ctx, &s3v2.AbortMultipartUploadInput{
+		Bucket:   awsv2.String(bucket),
+		Key:      awsv2.String("aborted"),
+		UploadId: cmuo.UploadId,
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n := s.Uploads(); n != 0 {
+		t.Errorf("expected no pending uploads but got %d", n)
+	}
+	if _, ok := s.Object(bucket, "aborted"); ok {
+		t.Error("expected aborted upload to leave no object")
+	}
+}
+
+func TestV2ContentMD5(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.CreateBucket(bucket)
+	client := s.ClientV2()
+	data := testData(10)
+	sum := md5.Sum(data)
+
+	for _, test := range []struct {
+		contentMD5 string
+		ok         bool
+	}{
+		{base64.StdEncoding.EncodeToString(sum[:]), true},
+		{base64.StdEncoding.EncodeToString(make([]byte, md5.Size)), false},
+	} {
+		_, err := client.PutObject(context.Background(), &s3v2.PutObjectInput{
+			Bucket:     awsv2.String(bucket),
+			Key:        awsv2.String("md5"),
+			Body:       bytes.NewReader(data),
+			ContentMD5: awsv2.String(test.contentMD5),
+		})
+		if test.ok && err != nil {
+			t.Errorf("expected error to be nil but got %q", err.Error())
+		}
+		if !test.ok && (err == nil || !strings.Contains(err.Error(), "BadDigest")) {
+			t.Errorf("expected a BadDigest error but got %v", err)
+		}
+	}
+}
+
+func TestV2Checksum(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.CreateBucket(bucket)
+	client := s.ClientV2()
+	data := testData(10)
+	sum := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+	crc := base64.StdEncoding.EncodeToString([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)})
+
+	for _, test := range []struct {
+		checksum string
+		ok       bool
+	}{
+		{crc, true},
+		{base64.StdEncoding.EncodeToString(make([]byte, 4)), false},
+	} {
+		_, err := client.PutObject(context.Background(), &s3v2.PutObjectInput{
+			Bucket: awsv2.String(bucket),
+			Key:    awsv2.String("crc"),
+			Body:   bytes.NewReader(data),
+		}, func(o *s3v2.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("X-Amz-Checksum-Crc32c", test.checksum))
+		})
+		if test.ok && err != nil {
+			t.Errorf("expected error to be nil but got %q", err.Error())
+		}
+		if !test.ok && (err == nil || !strings.Contains(err.Error(), "BadDigest")) {
+			t.Errorf("expected a BadDigest error but got %v", err)
+		}
+	}
+	if algorithm, checksum, _ := s.Checksum(bucket, "crc"); algorithm != "CRC32C" || checksum != crc {
+		t.Errorf("expected the CRC32C %s to be stored but got %s %s", crc, algorithm, checksum)
+	}
+}
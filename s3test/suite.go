@@ -0,0 +1,373 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+// Checksums mirrors the Checksums returned by the s3 and s3v2 writers
+type Checksums struct {
+	Algorithm string
+	CRC32C    string
+	SHA256    string
+	ETag      string
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+// Harness describes how to open the s3 or s3v2 files under test. Every
+// function works on keys of Bucket in Server.
+type Harness struct {
+	Server *Server
+	Bucket string
+	// NewWholeReader opens key with WithWholeObjectThreshold(threshold) and
+	// reports whether the object is held in memory
+	NewWholeReader func(t *testing.T, key string, threshold int64) (source.ParquetFile, bool)
+	// Verified returns the algorithm of the checksum the scan of a file
+	// returned by NewVerifiedReader was compared with, MD5 for the ETag, or
+	// an empty string when it was not verified
+	Verified func(pf source.ParquetFile) string
+	// ErrChecksumMismatch is the error failing the scan of a corrupted object
+	ErrChecksumMismatch error
+	// OpenVerifiedReader opens key with WithVerifyChecksum()
+	OpenVerifiedReader func(key string) (source.ParquetFile, error)
+	// ErrChecksumUnavailable is the error failing OpenVerifiedReader for an
+	// object without a checksum
+	ErrChecksumUnavailable error
+	// NewWriter creates key with WithChecksumAlgorithm(algorithm)
+	NewWriter func(t *testing.T, key string, algorithm string) source.ParquetFile
+	// Checksums returns the Checksums of a closed file returned by NewWriter
+	Checksums func(pf source.ParquetFile) Checksums
+	// Abort calls Abort on a file returned by NewWriter
+	Abort func(pf source.ParquetFile)
+}
+
+// Run exercises the whole object reads and the checksums of the s3 and s3v2
+// files described by h
+func Run(t *testing.T, h Harness) {
+	t.Run("WholeObjectConformance", func(t *testing.T) { testWholeObjectConformance(t, h) })
+	t.Run("WholeObject", func(t *testing.T) { testWholeObject(t, h) })
+	t.Run("Checksums", func(t *testing.T) { testChecksums(t, h) })
+	t.Run("VerifyChecksum", func(t *testing.T) { testVerifyChecksum(t, h) })
+	t.Run("VerifyChecksumUnavailable", func(t *testing.T) { testVerifyChecksumUnavailable(t, h) })
+	t.Run("VerifyChecksumOpenOtherKey", func(t *testing.T) { testVerifyChecksumOpenOtherKey(t, h) })
+	t.Run("AbortFailedUpload", func(t *testing.T) { testAbortFailedUpload(t, h) })
+}
+
+func testWholeObjectConformance(t *testing.T, h Harness) {
+	var files int
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			files++
+			key := fmt.Sprintf("whole/%d.parquet", files)
+			h.Server.PutObject(h.Bucket, key, data)
+			pf, whole := h.NewWholeReader(t, key, int64(len(data)))
+			if !whole {
+				t.Fatalf("expected the object to be held in memory")
+			}
+			return pf
+		},
+	})
+}
+
+func testWholeObject(t *testing.T, h Harness) {
+	data := sourcetest.Data()
+	h.Server.PutObject(h.Bucket, "small.parquet", data)
+
+	for _, test := range []struct {
+		name      string
+		threshold int64
+		gets      int
+	}{
+		{"below threshold", int64(len(data)), 1},
+		{"above threshold", int64(len(data)) - 1, 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h.Server.ResetRequests()
+			fr, _ := h.NewWholeReader(t, "small.parquet", test.threshold)
+			for i := 0; i < 4; i++ {
+				pf, err := fr.Open("")
+				if err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if _, err = pf.Seek(int64(i*100), io.SeekStart); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				b := make([]byte, 50)
+				if _, err = pf.Read(b); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if !bytes.Equal(b, data[i*100:i*100+50]) {
+					t.Errorf("expected the bytes at %d to match", i*100)
+				}
+			}
+
+			var gets int
+			for _, req := range h.Server.Requests() {
+				if req.Method == "GET" {
+					gets++
+				}
+			}
+			if gets != test.gets {
+				t.Errorf("expected %d GET requests but got %d", test.gets, gets)
+			}
+		})
+	}
+}
+
+// compositeChecksum returns the base64 encoded checksum S3 gives an object
+// uploaded in parts of partSize, or with a PutObject when partSize is 0
+func compositeChecksum(data []byte, partSize int, newHash func() hash.Hash) string {
+	sum := func(b []byte) []byte {
+		h := newHash()
+		h.Write(b)
+		return h.Sum(nil)
+	}
+	if partSize == 0 {
+		return base64.StdEncoding.EncodeToString(sum(data))
+	}
+	var sums []byte
+	var parts int
+	for i := 0; i < len(data); i += partSize {
+		end := i + partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sums = append(sums, sum(data[i:end])...)
+		parts++
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(sum(sums)), parts)
+}
+
+// writeAll writes data to a file returned by h.NewWriter in 1MB writes
+func writeAll(t *testing.T, h Harness, key string, algorithm string, data []byte) source.ParquetFile {
+	fw := h.NewWriter(t, key, algorithm)
+	for i := 0; i < len(data); i += 1024 * 1024 {
+		end := i + 1024*1024
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := fw.Write(data[i:end]); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return fw
+}
+
+func testChecksums(t *testing.T, h Harness) {
+	newCRC32C := func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }
+
+	for _, test := range []struct {
+		name      string
+		size      int
+		partSize  int
+		algorithm string
+	}{
+		{"put", 100, 0, "CRC32C"},
+		{"multipart", 11 * 1024 * 1024, 5 * 1024 * 1024, "CRC32C"},
+		{"multipart sha256", 11 * 1024 * 1024, 5 * 1024 * 1024, "SHA256"},
+		{"put without checksum", 100, 0, ""},
+		{"multipart without checksum", 11 * 1024 * 1024, 5 * 1024 * 1024, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data := testData(test.size)
+			key := strings.Replace(test.name, " ", "-", -1) + "-checksums.parquet"
+			h.Server.ResetRequests()
+			fw := writeAll(t, h, key, test.algorithm, data)
+
+			for _, req := range h.Server.Requests() {
+				if test.algorithm == "" {
+					// the requests are the ones the SDK sends by itself
+					for name := range req.Header {
+						if strings.HasPrefix(strings.ToLower(name), "x-amz-checksum") {
+							t.Errorf("expected no checksum header but got %s on %s", name, req.Method)
+						}
+					}
+					continue
+				}
+				if req.Method == "PUT" && req.Header.Get("Content-MD5") == "" {
+					t.Errorf("expected every body to be sent with its Content-MD5")
+				}
+			}
+			checksums := h.Checksums(fw)
+			var expected Checksums
+			if test.algorithm != "" {
+				etag, _ := h.Server.ETag(h.Bucket, key)
+				expected = Checksums{
+					Algorithm: test.algorithm,
+					CRC32C:    compositeChecksum(data, test.partSize, newCRC32C),
+					SHA256:    compositeChecksum(data, test.partSize, sha256.New),
+					ETag:      etag,
+				}
+			}
+			if checksums != expected {
+				t.Errorf("expected %+v but got %+v", expected, checksums)
+			}
+
+			// the checksum S3 stores is the one sent
+			algorithm, stored, _ := h.Server.Checksum(h.Bucket, key)
+			if algorithm != test.algorithm {
+				t.Errorf("expected the object to be stored with checksum algorithm %q but got %q", test.algorithm, algorithm)
+			}
+			if (algorithm == "CRC32C" && stored != checksums.CRC32C) || (algorithm == "SHA256" && stored != checksums.SHA256) {
+				t.Errorf("expected the stored checksum %s to match %+v", stored, checksums)
+			}
+		})
+	}
+}
+
+// newVerifiedReader opens key with h.OpenVerifiedReader
+func newVerifiedReader(t *testing.T, h Harness, key string) source.ParquetFile {
+	pf, err := h.OpenVerifiedReader(key)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return pf
+}
+
+func testVerifyChecksum(t *testing.T, h Harness) {
+	data := testData(11 * 1024 * 1024)
+	writeAll(t, h, "multipart.parquet", "CRC32C", data)
+	writeAll(t, h, "multipart-sha256.parquet", "SHA256", data)
+	writeAll(t, h, "put-crc32c.parquet", "CRC32C", data[:1000])
+	h.Server.PutObject(h.Bucket, "put.parquet", data[:1000])
+	h.Server.PutEncryptedObject(h.Bucket, "kms.parquet", data[:1000], "SHA256")
+
+	for _, test := range []struct {
+		name      string
+		key       string
+		size      int
+		algorithm string
+		corrupt   bool
+	}{
+		{"put", "put-crc32c.parquet", 1000, "CRC32C", false},
+		{"put without checksum", "put.parquet", 1000, "MD5", false},
+		{"kms", "kms.parquet", 1000, "SHA256", false},
+		{"multipart", "multipart.parquet", len(data), "CRC32C", false},
+		{"multipart sha256", "multipart-sha256.parquet", len(data), "SHA256", false},
+		{"put corrupted", "put-crc32c.parquet", 1000, "CRC32C", true},
+		{"put without checksum corrupted", "put.parquet", 1000, "MD5", true},
+		{"kms corrupted", "kms.parquet", 1000, "SHA256", true},
+		{"multipart corrupted", "multipart.parquet", len(data), "CRC32C", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h.Server.CorruptReads(test.corrupt)
+			defer h.Server.CorruptReads(false)
+			fr := newVerifiedReader(t, h, test.key)
+
+			var read []byte
+			b := make([]byte, 3*1024*1024+7)
+			for {
+				n, err := fr.Read(b)
+				read = append(read, b[:n]...)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					if test.corrupt && errors.Is(err, h.ErrChecksumMismatch) {
+						return
+					}
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+			}
+			if test.corrupt {
+				t.Fatalf("expected the scan to fail with %v", h.ErrChecksumMismatch)
+			}
+			if algorithm := h.Verified(fr); algorithm != test.algorithm {
+				t.Errorf("expected the scan to be verified against %q but got %q", test.algorithm, algorithm)
+			}
+			if len(read) != test.size {
+				t.Errorf("expected to read %d bytes but got %d", test.size, len(read))
+			}
+			if !bytes.Equal(read, data[:test.size]) {
+				t.Errorf("expected the data read to match")
+			}
+		})
+	}
+}
+
+func testVerifyChecksumUnavailable(t *testing.T, h Harness) {
+	h.Server.PutEncryptedObject(h.Bucket, "kms-without-checksum.parquet", testData(1000), "")
+
+	_, err := h.OpenVerifiedReader("kms-without-checksum.parquet")
+	if !errors.Is(err, h.ErrChecksumUnavailable) {
+		t.Fatalf("expected error %v but got %v", h.ErrChecksumUnavailable, err)
+	}
+}
+
+func testVerifyChecksumOpenOtherKey(t *testing.T, h Harness) {
+	data := testData(1000)
+	h.Server.PutObject(h.Bucket, "first.parquet", data[:100])
+	h.Server.PutObject(h.Bucket, "second.parquet", data)
+
+	fr := newVerifiedReader(t, h, "first.parquet")
+	pf, err := fr.Open("second.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	size, err := pf.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if size != int64(len(data)) {
+		t.Errorf("expected size %d but got %d", len(data), size)
+	}
+	if _, err = pf.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	read := make([]byte, len(data))
+	if _, err = io.ReadFull(pf, read); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("expected the data read to match")
+	}
+}
+
+// testAbortFailedUpload checks that Close reports an upload that failed
+// before Abort
+func testAbortFailedUpload(t *testing.T, h Harness) {
+	h.Server.DenyWrites(true)
+	defer h.Server.DenyWrites(false)
+
+	pf := h.NewWriter(t, "denied.parquet", "")
+	// the multipart upload starts, and fails, once a part is full
+	data := testData(6 * 1024 * 1024)
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, err = pf.Write(data)
+	}
+	if err == nil {
+		t.Fatal("expected Write to fail once the upload failed")
+	}
+
+	h.Abort(pf)
+	for i := 0; i < 2; i++ {
+		if err = pf.Close(); err == nil {
+			t.Errorf("expected Close to return the error of the upload")
+		}
+	}
+	if _, ok := h.Server.Object(h.Bucket, "denied.parquet"); ok {
+		t.Errorf("expected no object to be created")
+	}
+}
@@ -0,0 +1,218 @@
+package s3v2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/checksum"
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+var (
+	// ErrChecksumMismatch is returned by the read completing a sequential
+	// scan of an object when the bytes read do not match its checksum
+	ErrChecksumMismatch = checksum.ErrMismatch
+	// ErrChecksumUnavailable is returned when opening a reader created with
+	// WithVerifyChecksum for an object that has no checksum to verify
+	ErrChecksumUnavailable = checksum.ErrUnavailable
+)
+
+// Algorithms of the checksums sent with every body of an upload, see
+// WithChecksumAlgorithm
+const (
+	ChecksumCRC32C = checksum.CRC32C
+	ChecksumSHA256 = checksum.SHA256
+)
+
+// Checksums of the bytes written to an S3File, complete once Close returned.
+// They are only computed when the writer was created with
+// WithChecksumAlgorithm.
+type Checksums struct {
+	// Algorithm is the one of the checksum sent with every body and checked
+	// by S3
+	Algorithm string
+	// CRC32C and SHA256 are base64 encoded, like the x-amz-checksum headers.
+	// The checksum of a multipart upload is the checksum of the checksums
+	// of its parts followed by their number, as in "...-3". S3 returns the
+	// one of Algorithm for the object.
+	CRC32C string
+	SHA256 string
+	// ETag is computed from the MD5 of the uploaded parts, each part being
+	// sent with its Content-MD5. S3 gives the same ETag to objects that are
+	// not encrypted with KMS or a customer key.
+	ETag string
+}
+
+// Checksums returns the checksums of the bytes written to s
+func (s *S3File) Checksums() Checksums {
+	if s.parts == nil {
+		return Checksums{}
+	}
+	return Checksums{
+		Algorithm: s.checksumAlgorithm,
+		CRC32C:    s.parts.CRC32C(),
+		SHA256:    s.parts.SHA256(),
+		ETag:      s.parts.ETag(),
+	}
+}
+
+// checksumClient sends the Content-MD5 and the checksum of algorithm of
+// every body the uploader sends, and records them to compute the checksums
+// of the object. The upload is created with algorithm, headers and elements
+// the SDK does not know about. It is only used when an algorithm is set.
+type checksumClient struct {
+	S3API
+	parts     *checksum.Parts
+	algorithm string
+}
+
+func (c checksumClient) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if body, ok := in.Body.(io.ReadSeeker); ok {
+		sums, err := c.parts.Add(0, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "c.parts.Add")
+		}
+		if in.ContentMD5 == nil {
+			in.ContentMD5 = aws.String(sums.MD5)
+		}
+		optFns = append(optFns, c.sumHeader(sums))
+	}
+	return c.S3API.PutObject(ctx, in, optFns...)
+}
+
+func (c checksumClient) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	optFns = append(optFns, setHeader("X-Amz-Checksum-Algorithm", c.algorithm))
+	return c.S3API.CreateMultipartUpload(ctx, in, optFns...)
+}
+
+func (c checksumClient) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if body, ok := in.Body.(io.ReadSeeker); ok {
+		sums, err := c.parts.Add(int64(in.PartNumber), body)
+		if err != nil {
+			return nil, errors.Wrap(err, "c.parts.Add")
+		}
+		in.ContentMD5 = aws.String(sums.MD5)
+		optFns = append(optFns, c.sumHeader(sums))
+	}
+	return c.S3API.UploadPart(ctx, in, optFns...)
+}
+
+// CompleteMultipartUpload replaces the body serialized by the SDK with one
+// listing the checksum of every part
+func (c checksumClient) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if in.MultipartUpload != nil {
+		parts := make([]checksum.CompletedPart, len(in.MultipartUpload.Parts))
+		for i, part := range in.MultipartUpload.Parts {
+			parts[i] = checksum.CompletedPart{Number: int64(part.PartNumber), ETag: aws.ToString(part.ETag)}
+		}
+		body, err := c.parts.CompleteMultipartUpload(c.algorithm, parts)
+		if err != nil {
+			return nil, errors.Wrap(err, "c.parts.CompleteMultipartUpload")
+		}
+		optFns = append(optFns, setBody(body))
+	}
+	return c.S3API.CompleteMultipartUpload(ctx, in, optFns...)
+}
+
+// sumHeader sets the header carrying the checksum of a body
+func (c checksumClient) sumHeader(sums checksum.Sums) func(*s3.Options) {
+	return setHeader(checksum.Header(c.algorithm), sums.Get(c.algorithm))
+}
+
+func setHeader(name string, value string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue(name, value))
+	}
+}
+
+// setBody replaces the serialized body of a request with body
+func setBody(body []byte) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("setBody", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					req, err := req.SetStream(bytes.NewReader(body))
+					if err != nil {
+						return middleware.BuildOutput{}, middleware.Metadata{}, errors.Wrap(err, "req.SetStream")
+					}
+					req.ContentLength = int64(len(body))
+					in.Request = req
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+		})
+	}
+}
+
+// checksumMode makes S3 return the stored checksum of an object
+var checksumMode = setHeader("X-Amz-Checksum-Mode", "ENABLED")
+
+// responseHeader stores the header of the response in header, the SDK only
+// returns the headers it knows about
+func responseHeader(header *http.Header) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("responseHeader", func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+				out, metadata, err := next.HandleDeserialize(ctx, in)
+				if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+					*header = resp.Header
+				}
+				return out, metadata, err
+			}), middleware.After)
+		})
+	}
+}
+
+// storedChecksum returns the checksum of the object described by hoo and
+// header, the response to a HeadObject sent with checksumMode
+func storedChecksum(hoo *s3.HeadObjectOutput, header http.Header) (checksum.Sum, error) {
+	encrypted := hoo.ServerSideEncryption == types.ServerSideEncryptionAwsKms || hoo.SSECustomerAlgorithm != nil
+	sum, err := checksum.Stored(header, aws.ToString(hoo.ETag), encrypted)
+	if err != nil {
+		return checksum.Sum{}, errors.Wrap(err, "checksum.Stored")
+	}
+	return sum, nil
+}
+
+// verify passes the bytes read at off to the verifier of s, which is started
+// by a read at the start of the object
+func (s *S3File) verify(off int64, p []byte) error {
+	if s.verifier == nil {
+		if off != 0 || s.fileSize == 0 {
+			return nil
+		}
+
+		var partSize int64
+		if s.stored.Multipart() {
+			// every part but the last one has the size of the first one
+			hoi := &s3.HeadObjectInput{
+				Bucket:     aws.String(s.BucketName),
+				Key:        aws.String(s.Key),
+				VersionId:  s.VersionId,
+				IfMatch:    aws.String(s.ETag),
+				PartNumber: 1,
+			}
+			ctx, span := trace.Start(s.ctx, "s3.HeadObject", objectAttributes(hoi.Bucket, hoi.Key, trace.Int64(trace.AttrPart, 1))...)
+			hoo, err := s.client.HeadObject(ctx, hoi)
+			span.End(err)
+			if err != nil {
+				return errors.Wrap(err, "s.client.HeadObject")
+			}
+			partSize = hoo.ContentLength
+		}
+		s.verifier = checksum.NewVerifier(s.stored, s.fileSize, partSize)
+	}
+
+	if err := s.verifier.Write(off, p); err != nil {
+		return errors.Wrap(err, "s.verifier.Write")
+	}
+	return nil
+}
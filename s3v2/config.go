@@ -14,18 +14,19 @@ var (
 	configMu sync.Mutex
 )
 
-// Config from shared config rather than explicit configuration
-func getConfig() aws.Config {
+// Config from shared config rather than explicit configuration, a failed
+// load is retried on the next call
+func getConfig() (aws.Config, error) {
 	configMu.Lock()
 	defer configMu.Unlock()
 
 	if cfg != nil {
-		return *cfg
+		return *cfg, nil
 	}
 	c, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
-		panic(errors.Wrap(err, "config.LoadDefaultConfig"))
+		return aws.Config{}, errors.Wrap(err, "config.LoadDefaultConfig")
 	}
 	cfg = &c
-	return *cfg
+	return *cfg, nil
 }
@@ -0,0 +1,333 @@
+package s3v2
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go/source"
+)
+
+// Option configures the S3 client of NewS3FileReaderWithOptions and
+// NewS3FileWriterWithOptions, the version read by a reader or the objects
+// written by a writer
+type Option func(*options)
+
+type options struct {
+	client               S3API
+	config               *aws.Config
+	region               string
+	endpoint             string
+	pathStyle            bool
+	s3Options            []func(*s3.Options)
+	uploaderOptions      []func(*manager.Uploader)
+	putOptions           []func(*s3.PutObjectInput)
+	versionID            *string
+	noOverwrite          bool
+	checksumAlgorithm    string
+	verifyChecksum       bool
+	wholeObjectThreshold int64
+}
+
+// WithClient uses client as is, the options configuring a client are ignored
+func WithClient(client S3API) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithConfig creates the client from cfg instead of the shared configuration
+// files and environment
+func WithConfig(cfg aws.Config) Option {
+	return func(o *options) {
+		o.config = &cfg
+	}
+}
+
+// WithRegion overrides the region of the configuration
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithEndpoint sends every request to url, such as a MinIO server
+func WithEndpoint(url string) Option {
+	return func(o *options) {
+		o.endpoint = url
+	}
+}
+
+// WithPathStyle addresses buckets in the path of the URL rather than as a
+// subdomain, as most S3 compatible servers expect
+func WithPathStyle() Option {
+	return func(o *options) {
+		o.pathStyle = true
+	}
+}
+
+// WithS3Options adds functions modifying the options of the client
+func WithS3Options(fns ...func(*s3.Options)) Option {
+	return func(o *options) {
+		o.s3Options = append(o.s3Options, fns...)
+	}
+}
+
+// WithUploaderOptions adds functions modifying the uploader of a writer
+func WithUploaderOptions(fns ...func(*manager.Uploader)) Option {
+	return func(o *options) {
+		o.uploaderOptions = append(o.uploaderOptions, fns...)
+	}
+}
+
+// WithVersionID makes a reader read a version of the object rather than the
+// current one
+func WithVersionID(versionID string) Option {
+	return func(o *options) {
+		o.versionID = aws.String(versionID)
+	}
+}
+
+// WithVerifyChecksum makes a reader compare the bytes of a sequential scan of
+// the object, from its first to its last byte, with the CRC32C or SHA-256
+// checksum S3 stores for it. The read reaching the end fails with
+// ErrChecksumMismatch when they differ. Objects written without a checksum
+// are compared with their ETag, and opening one fails with
+// ErrChecksumUnavailable when it is encrypted with KMS or a customer key, as
+// its ETag is not its MD5.
+func WithVerifyChecksum() Option {
+	return func(o *options) {
+		o.verifyChecksum = true
+	}
+}
+
+// WithChecksumAlgorithm makes a writer send the checksum of algorithm,
+// ChecksumCRC32C or ChecksumSHA256, with every body of the upload so that S3
+// rejects corrupted parts and stores the checksum of the object. No checksum
+// is sent by default, as some S3 compatible servers do not support them.
+func WithChecksumAlgorithm(algorithm string) Option {
+	return func(o *options) {
+		o.checksumAlgorithm = algorithm
+	}
+}
+
+// WithNoOverwrite makes a writer create the object only if the key does not
+// exist yet, sending If-None-Match: * with the PutObject or
+// CompleteMultipartUpload request. Close returns ErrObjectExists otherwise.
+func WithNoOverwrite() Option {
+	return func(o *options) {
+		o.noOverwrite = true
+	}
+}
+
+// WithPutObjectInput adds functions modifying the PutObjectInput of a writer,
+// its fields are also used for the multipart uploads of large files
+func WithPutObjectInput(fns ...func(*s3.PutObjectInput)) Option {
+	return func(o *options) {
+		o.putOptions = append(o.putOptions, fns...)
+	}
+}
+
+// WithSSES3 encrypts the written object with keys managed by S3
+func WithSSES3() Option {
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.ServerSideEncryption = types.ServerSideEncryptionAes256
+	})
+}
+
+// WithSSEKMS encrypts the written object with a KMS key, the default key of
+// the account is used when keyID is empty. encryptionContext may be nil.
+func WithSSEKMS(keyID string, encryptionContext map[string]string) Option {
+	var encodedContext *string
+	if len(encryptionContext) > 0 {
+		// the context is sent as base64 encoded JSON, encoding a map of
+		// strings cannot fail
+		b, _ := json.Marshal(encryptionContext)
+		encodedContext = aws.String(base64.StdEncoding.EncodeToString(b))
+	}
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if keyID != "" {
+			in.SSEKMSKeyId = aws.String(keyID)
+		}
+		in.SSEKMSEncryptionContext = encodedContext
+	})
+}
+
+// WithSSECustomerKey encrypts the written object with a 256-bit key provided
+// by the caller, the same key is needed to read the object
+func WithSSECustomerKey(key []byte) Option {
+	sum := md5.Sum(key)
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.SSECustomerAlgorithm = aws.String("AES256")
+		in.SSECustomerKey = aws.String(base64.StdEncoding.EncodeToString(key))
+		in.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	})
+}
+
+// customerKeyClient adds the MD5 of the customer key to the UploadPart
+// requests, the uploader only copies the algorithm and the key
+type customerKeyClient struct {
+	S3API
+	keyMD5 *string
+}
+
+func (c customerKeyClient) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if in.SSECustomerKeyMD5 == nil {
+		in.SSECustomerKeyMD5 = c.keyMD5
+	}
+	return c.S3API.UploadPart(ctx, in, optFns...)
+}
+
+// noOverwriteClient makes the requests creating the object conditional on
+// the key not existing
+type noOverwriteClient struct {
+	S3API
+}
+
+func ifNoneMatch(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", "*"))
+}
+
+func (c noOverwriteClient) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return c.S3API.PutObject(ctx, in, append(optFns, ifNoneMatch)...)
+}
+
+func (c noOverwriteClient) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return c.S3API.CompleteMultipartUpload(ctx, in, append(optFns, ifNoneMatch)...)
+}
+
+// WithStorageClass sets the storage class of the written object
+func WithStorageClass(storageClass types.StorageClass) Option {
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.StorageClass = storageClass
+	})
+}
+
+// WithContentType sets the Content-Type of the written object
+func WithContentType(contentType string) Option {
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.ContentType = aws.String(contentType)
+	})
+}
+
+// WithCacheControl sets the Cache-Control of the written object
+func WithCacheControl(cacheControl string) Option {
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.CacheControl = aws.String(cacheControl)
+	})
+}
+
+// WithMetadata adds user metadata to the written object
+func WithMetadata(metadata map[string]string) Option {
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		if in.Metadata == nil {
+			in.Metadata = map[string]string{}
+		}
+		for k, v := range metadata {
+			in.Metadata[k] = v
+		}
+	})
+}
+
+// WithTags tags the written object
+func WithTags(tags map[string]string) Option {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	tagging := values.Encode()
+	return WithPutObjectInput(func(in *s3.PutObjectInput) {
+		in.Tagging = aws.String(tagging)
+	})
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// newClient returns the client described by o, the configuration is loaded
+// from the environment when none was given
+func (o *options) newClient() (S3API, error) {
+	if o.client != nil {
+		return o.client, nil
+	}
+
+	var cfg aws.Config
+	if o.config != nil {
+		cfg = *o.config
+	} else {
+		var err error
+		if cfg, err = getConfig(); err != nil {
+			return nil, errors.Wrap(err, "getConfig")
+		}
+	}
+
+	fns := make([]func(*s3.Options), 0, len(o.s3Options)+1)
+	fns = append(fns, func(so *s3.Options) {
+		if o.region != "" {
+			so.Region = o.region
+		}
+		if o.endpoint != "" {
+			so.EndpointResolver = s3.EndpointResolverFromURL(o.endpoint)
+		}
+		if o.pathStyle {
+			so.UsePathStyle = true
+		}
+	})
+	fns = append(fns, o.s3Options...)
+	return s3.NewFromConfig(cfg, fns...), nil
+}
+
+// configOptions returns the options of the constructors taking a list of
+// configurations, the last one is used
+func configOptions(cfgs []*aws.Config) []Option {
+	var opts []Option
+	for _, cfg := range cfgs {
+		if cfg != nil {
+			opts = append(opts, WithConfig(*cfg))
+		}
+	}
+	return opts
+}
+
+// NewS3FileReaderWithOptions creates an S3 FileReader, to be used with
+// NewParquetReader
+func NewS3FileReaderWithOptions(ctx context.Context, bucket string, key string, opts ...Option) (source.ParquetFile, error) {
+	o := newOptions(opts)
+	client, err := o.newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+	pf, err := NewS3FileReaderVersionedWithClient(ctx, client, bucket, key, o.versionID, opts...)
+	if err != nil {
+		return pf, errors.Wrap(err, "NewS3FileReaderVersionedWithClient")
+	}
+	return pf, nil
+}
+
+// NewS3FileWriterWithOptions creates an S3 FileWriter, to be used with
+// NewParquetWriter
+func NewS3FileWriterWithOptions(ctx context.Context, bucket string, key string, opts ...Option) (source.ParquetFile, error) {
+	client, err := newOptions(opts).newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+	pf, err := NewS3FileWriterWithClient(ctx, client, bucket, key, nil, opts...)
+	if err != nil {
+		return pf, errors.Wrap(err, "NewS3FileWriterWithClient")
+	}
+	return pf, nil
+}
@@ -0,0 +1,220 @@
+package s3v2
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+)
+
+// recordingClient fails every request after recording it
+type recordingClient struct {
+	requests []*http.Request
+}
+
+func (c *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	return nil, errors.New("recordingClient")
+}
+
+func testConfig(httpClient aws.HTTPClient) aws.Config {
+	return aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test", Source: "test"}, nil
+		}),
+		HTTPClient: httpClient,
+		Retryer: func() aws.Retryer {
+			return aws.NopRetryer{}
+		},
+	}
+}
+
+func TestOptionsRoundTrip(t *testing.T) {
+	server, _ := newFakeS3(t)
+	data := []byte("PAR1 some data PAR1")
+
+	var partSize int64
+	opts := []Option{
+		WithConfig(testConfig(nil)),
+		WithEndpoint(server.URL),
+		WithPathStyle(),
+		WithUploaderOptions(func(u *manager.Uploader) {
+			partSize = u.PartSize
+		}),
+	}
+	fw, err := NewS3FileWriterWithOptions(context.Background(), "test-bucket", "options.parquet", opts...)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fw.Write(data); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if partSize != manager.DefaultUploadPartSize {
+		t.Errorf("expected the uploader options to be applied")
+	}
+
+	fr, err := NewS3FileReaderWithOptions(context.Background(), "test-bucket", "options.parquet", opts...)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, len(data))
+	if _, err = fr.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("expected %q but got %q", data, b)
+	}
+}
+
+func TestOptionsClient(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		opts   []Option
+		host   string
+		path   string
+		region string
+	}{
+		{"config", nil, "test-bucket.s3.us-east-1.amazonaws.com", "/key", "us-east-1"},
+		{"region", []Option{WithRegion("eu-west-3")}, "test-bucket.s3.eu-west-3.amazonaws.com", "/key", "eu-west-3"},
+		{"endpoint", []Option{WithEndpoint("http://minio:9000"), WithPathStyle()}, "minio:9000", "/test-bucket/key", "us-east-1"},
+		{"s3 options", []Option{WithS3Options(func(o *s3.Options) {
+			o.UsePathStyle = true
+		})}, "s3.us-east-1.amazonaws.com", "/test-bucket/key", "us-east-1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			httpClient := &recordingClient{}
+			opts := append([]Option{WithConfig(testConfig(httpClient))}, test.opts...)
+			if _, err := NewS3FileReaderWithOptions(context.Background(), "test-bucket", "key", opts...); err == nil {
+				t.Fatal("expected the HeadObject request to fail")
+			}
+			if len(httpClient.requests) != 1 {
+				t.Fatalf("expected 1 request but got %d", len(httpClient.requests))
+			}
+			req := httpClient.requests[0]
+			if req.URL.Host != test.host || req.URL.Path != test.path {
+				t.Errorf("expected a request to %s%s but got %s%s", test.host, test.path, req.URL.Host, req.URL.Path)
+			}
+			if auth := req.Header.Get("Authorization"); !strings.Contains(auth, "/"+test.region+"/s3/") {
+				t.Errorf("expected the request to be signed for %s but got %q", test.region, auth)
+			}
+		})
+	}
+}
+
+func TestLegacyConfig(t *testing.T) {
+	httpClient := &recordingClient{}
+	cfg := testConfig(httpClient)
+	if _, err := NewS3FileReader(context.Background(), "test-bucket", "key", &cfg); err == nil {
+		t.Fatal("expected the HeadObject request to fail")
+	}
+	if len(httpClient.requests) != 1 {
+		t.Errorf("expected the request to be sent with the given configuration but got %d requests", len(httpClient.requests))
+	}
+}
+
+func TestWriteOptions(t *testing.T) {
+	server, _ := newFakeS3(t)
+	key := bytes.Repeat([]byte{7}, 32)
+	opts := []Option{
+		WithSSEKMS("key-id", map[string]string{"team": "data"}),
+		WithStorageClass(types.StorageClassStandardIa),
+		WithContentType("application/vnd.apache.parquet"),
+		WithCacheControl("no-cache"),
+		WithMetadata(map[string]string{"source": "test"}),
+		WithTags(map[string]string{"retention": "30d", "owner": "data team"}),
+	}
+	expected := map[string]string{
+		"X-Amz-Server-Side-Encryption":                "aws:kms",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "key-id",
+		"X-Amz-Server-Side-Encryption-Context":        base64.StdEncoding.EncodeToString([]byte(`{"team":"data"}`)),
+		"X-Amz-Storage-Class":                         "STANDARD_IA",
+		"Content-Type":                                "application/vnd.apache.parquet",
+		"Cache-Control":                               "no-cache",
+		"X-Amz-Meta-Source":                           "test",
+		"X-Amz-Tagging":                               "owner=data+team&retention=30d",
+	}
+
+	for _, test := range []struct {
+		name   string
+		size   int
+		method string
+		// query identifies the request creating the object
+		query string
+	}{
+		{"put", 100, http.MethodPut, ""},
+		{"multipart", 6 * 1024 * 1024, http.MethodPost, "uploads"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server.ResetRequests()
+			fw, err := NewS3FileWriterWithClient(context.Background(), server.ClientV2(), "test-bucket", test.name+".parquet", nil, opts...)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			// Create keeps the options
+			if fw, err = fw.Create(test.name + ".parquet"); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err = fw.Write(make([]byte, test.size)); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if err = fw.Close(); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			var found bool
+			for _, req := range server.Requests() {
+				if req.Key != test.name+".parquet" || req.Method != test.method || !strings.Contains(req.Query, test.query) {
+					continue
+				}
+				found = true
+				for header, value := range expected {
+					if got := req.Header.Get(header); got != value {
+						t.Errorf("expected %s to be %q but got %q", header, value, got)
+					}
+				}
+			}
+			if !found {
+				t.Fatalf("expected a %s %s request", test.method, test.query)
+			}
+		})
+	}
+
+	server.ResetRequests()
+	fw, err := NewS3FileWriterWithClient(context.Background(), server.ClientV2(), "test-bucket", "ssec.parquet", nil, WithSSECustomerKey(key))
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fw.Write(make([]byte, 6*1024*1024)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	sum := md5.Sum(key)
+	for _, req := range server.Requests() {
+		if req.Method != http.MethodPut && req.Method != http.MethodPost {
+			continue
+		}
+		if strings.Contains(req.Query, "uploadId") && req.Method == http.MethodPost {
+			// CompleteMultipartUpload does not carry the key
+			continue
+		}
+		if req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != base64.StdEncoding.EncodeToString(key) ||
+			req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Errorf("expected the %s %s request to carry the customer key", req.Method, req.Query)
+		}
+	}
+}
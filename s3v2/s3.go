@@ -6,12 +6,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/internal/checksum"
+	"github.com/sabey/parquet-go-source/internal/sourceutil"
+	"github.com/sabey/parquet-go-source/trace"
 	"github.com/sabey/parquet-go/source"
 )
 
@@ -39,16 +43,33 @@ type S3File struct {
 	pipeWriter      *io.PipeWriter
 	uploader        *manager.Uploader
 	uploaderOptions []func(*manager.Uploader)
+	putOptions      []func(*s3.PutObjectInput)
+	noOverwrite     bool
+	// closed is set once Close waited for the upload, which returned closeErr
+	closed   bool
+	closeErr error
+	// checksumAlgorithm is sent with every body, see WithChecksumAlgorithm
+	checksumAlgorithm string
+	parts             *checksum.Parts
 
 	// read-related fields
-	readOpened bool
-	fileSize   int64
-	downloader *manager.Downloader
+	readOpened     bool
+	fileSize       int64
+	downloader     *manager.Downloader
+	verifyChecksum bool
+	// stored is the checksum a sequential scan is verified against
+	stored   checksum.Sum
+	verifier *checksum.Verifier
+	// whole holds the object when it is smaller than wholeThreshold
+	wholeThreshold int64
+	whole          []byte
 
 	lock       sync.RWMutex
 	err        error
 	BucketName string
 	Key        string
+	VersionId  *string
+	ETag       string
 }
 
 const (
@@ -60,9 +81,21 @@ var (
 	errWhence        = errors.New("Seek: invalid whence")
 	errInvalidOffset = errors.New("Seek: invalid offset")
 	errFailedUpload  = errors.New("Write: failed upload")
+
+	// ErrAborted is returned by writes after Abort
+	ErrAborted = errors.New("Write: aborted")
+	// ErrObjectExists is returned by Close when a writer created with
+	// WithNoOverwrite finds an object at its key
+	ErrObjectExists = errors.New("Close: object already exists")
+
+	// ErrObjectChanged is returned by reads when the object no longer has
+	// the ETag it had when the reader was opened
+	ErrObjectChanged = errors.New("Read: object changed")
 )
 
-// NewS3FileWriter creates an S3 FileWriter, to be used with NewParquetWriter
+// NewS3FileWriter creates an S3 FileWriter, to be used with NewParquetWriter.
+// The client is created from the last of cfgs, or from the shared
+// configuration when cfgs is empty.
 func NewS3FileWriter(
 	ctx context.Context,
 	bucket string,
@@ -70,35 +103,39 @@ func NewS3FileWriter(
 	uploaderOptions []func(*manager.Uploader),
 	cfgs ...*aws.Config,
 ) (source.ParquetFile, error) {
-	pf, err := NewS3FileWriterWithClient(
-		ctx,
-		s3.NewFromConfig(getConfig()),
-		bucket,
-		key,
-		uploaderOptions,
-	)
+	opts := append(configOptions(cfgs), WithUploaderOptions(uploaderOptions...))
+	pf, err := NewS3FileWriterWithOptions(ctx, bucket, key, opts...)
 	if err != nil {
-		return pf, errors.Wrap(err, "NewS3FileWriterWithClient")
+		return pf, errors.Wrap(err, "NewS3FileWriterWithOptions")
 	}
 	return pf, nil
 }
 
 // NewS3FileWriterWithClient is the same as NewS3FileWriter but allows passing
-// your own S3 client.
+// your own S3 client. Of opts, only the options of the object and the
+// uploader apply, such as WithSSEKMS or WithTags.
 func NewS3FileWriterWithClient(
 	ctx context.Context,
 	s3Client S3API,
 	bucket string,
 	key string,
 	uploaderOptions []func(*manager.Uploader),
+	opts ...Option,
 ) (source.ParquetFile, error) {
+	o := newOptions(opts)
 	file := &S3File{
-		ctx:             ctx,
-		client:          s3Client,
-		writeDone:       make(chan error),
-		uploaderOptions: uploaderOptions,
-		BucketName:      bucket,
-		Key:             key,
+		ctx:               ctx,
+		client:            s3Client,
+		writeDone:         make(chan error),
+		uploaderOptions:   append(uploaderOptions, o.uploaderOptions...),
+		putOptions:        o.putOptions,
+		noOverwrite:       o.noOverwrite,
+		checksumAlgorithm: o.checksumAlgorithm,
+		BucketName:        bucket,
+		Key:               key,
+	}
+	if file.checksumAlgorithm != "" && !checksum.Valid(file.checksumAlgorithm) {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", file.checksumAlgorithm)
 	}
 
 	pf, err := file.Create(key)
@@ -108,26 +145,62 @@ func NewS3FileWriterWithClient(
 	return pf, nil
 }
 
-// NewS3FileReader creates an S3 FileReader, to be used with NewParquetReader
+// NewS3FileReader creates an S3 FileReader, to be used with NewParquetReader.
+// The client is created from the last of cfgs, or from the shared
+// configuration when cfgs is empty.
 func NewS3FileReader(ctx context.Context, bucket string, key string, cfgs ...*aws.Config) (source.ParquetFile, error) {
-	pf, err := NewS3FileReaderWithClient(ctx, s3.NewFromConfig(getConfig()), bucket, key)
+	pf, err := NewS3FileReaderWithOptions(ctx, bucket, key, configOptions(cfgs)...)
 	if err != nil {
-		return pf, errors.Wrap(err, "NewS3FileReaderWithClient")
+		return pf, errors.Wrap(err, "NewS3FileReaderWithOptions")
 	}
 	return pf, nil
 }
 
 // NewS3FileReaderWithClient is the same as NewS3FileReader but allows passing
-// your own S3 client
-func NewS3FileReaderWithClient(ctx context.Context, s3Client S3API, bucket string, key string) (source.ParquetFile, error) {
+// your own S3 client. Of opts, only the options of the reader apply, such as
+// WithVerifyChecksum or WithVersionID.
+func NewS3FileReaderWithClient(ctx context.Context, s3Client S3API, bucket string, key string, opts ...Option) (source.ParquetFile, error) {
+	pf, err := NewS3FileReaderVersionedWithClient(ctx, s3Client, bucket, key, nil, opts...)
+	if err != nil {
+		return pf, errors.Wrap(err, "NewS3FileReaderVersionedWithClient")
+	}
+	return pf, nil
+}
+
+// NewS3FileReaderVersioned creates an S3 FileReader for a version of an S3
+// object, the current version is read when version is nil
+func NewS3FileReaderVersioned(ctx context.Context, bucket string, key string, version *string, cfgs ...*aws.Config) (source.ParquetFile, error) {
+	opts := configOptions(cfgs)
+	if version != nil {
+		opts = append(opts, WithVersionID(*version))
+	}
+	pf, err := NewS3FileReaderWithOptions(ctx, bucket, key, opts...)
+	if err != nil {
+		return pf, errors.Wrap(err, "NewS3FileReaderWithOptions")
+	}
+	return pf, nil
+}
+
+// NewS3FileReaderVersionedWithClient is the same as NewS3FileReaderVersioned
+// but allows passing your own S3 client. Every read is pinned to the ETag
+// returned by HeadObject and fails with ErrObjectChanged once the object is
+// overwritten. WithVersionID applies when version is nil.
+func NewS3FileReaderVersionedWithClient(ctx context.Context, s3Client S3API, bucket string, key string, version *string, opts ...Option) (source.ParquetFile, error) {
+	o := newOptions(opts)
+	if version == nil {
+		version = o.versionID
+	}
 	s3Downloader := manager.NewDownloader(s3Client)
 
 	file := &S3File{
-		ctx:        ctx,
-		client:     s3Client,
-		downloader: s3Downloader,
-		BucketName: bucket,
-		Key:        key,
+		ctx:            ctx,
+		client:         s3Client,
+		downloader:     s3Downloader,
+		BucketName:     bucket,
+		Key:            key,
+		VersionId:      version,
+		verifyChecksum: o.verifyChecksum,
+		wholeThreshold: o.wholeObjectThreshold,
 	}
 
 	pf, err := file.Open(key)
@@ -137,7 +210,10 @@ func NewS3FileReaderWithClient(ctx context.Context, s3Client S3API, bucket strin
 	return pf, nil
 }
 
-// Seek tracks the offset for the next Read. Has no effect on Write.
+// Seek tracks the offset for the next Read and returns it. Has no effect on
+// Write. io.SeekEnd offsets are made absolute once the size is known, for
+// objects of unknown size they stay relative to the end and are returned as
+// given.
 func (s *S3File) Seek(offset int64, whence int) (int64, error) {
 	if whence < io.SeekStart || whence > io.SeekEnd {
 		return 0, errors.Wrap(errWhence, "errWhence")
@@ -155,9 +231,11 @@ func (s *S3File) Seek(offset int64, whence int) (int64, error) {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
 		case io.SeekEnd:
-			if offset > -1 || -offset > s.fileSize {
+			if offset > 0 || -offset > s.fileSize {
 				return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
 			}
+			offset += s.fileSize
+			whence = io.SeekStart
 		}
 	}
 
@@ -171,21 +249,25 @@ func (s *S3File) Read(p []byte) (n int, err error) {
 	if s.fileSize > 0 && s.offset >= s.fileSize {
 		return 0, errors.Wrap(io.EOF, "io.EOF")
 	}
+	if s.whole != nil {
+		return s.readWhole(p)
+	}
 
 	numBytes := len(p)
+	start, whence := s.offset, s.whence
 	getObjRange := s.getBytesRange(numBytes)
-	getObj := &s3.GetObjectInput{
-		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(s.Key),
-	}
+	getObj := s.getObjectInput()
 	if len(getObjRange) > 0 {
 		getObj.Range = aws.String(getObjRange)
 	}
 
+	ctx, span := trace.Start(s.ctx, "s3.GetObject", objectAttributes(getObj.Bucket, getObj.Key, trace.String(trace.AttrRange, getObjRange))...)
 	wab := manager.NewWriteAtBuffer(p)
-	bytesDownloaded, err := s.downloader.Download(s.ctx, wab, getObj)
+	bytesDownloaded, err := s.downloader.Download(ctx, wab, getObj)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, bytesDownloaded))
+	span.End(err)
 	if err != nil {
-		return 0, errors.Wrap(err, "s.downloader.Download")
+		return 0, downloadError(err)
 	}
 
 	s.offset += bytesDownloaded
@@ -195,9 +277,102 @@ func (s *S3File) Read(p []byte) (n int, err error) {
 		bytesDownloaded = int64(len(p))
 	}
 
+	if s.verifyChecksum && whence != io.SeekEnd {
+		if err = s.verify(start, p[:bytesDownloaded]); err != nil {
+			return int(bytesDownloaded), errors.Wrap(err, "s.verify")
+		}
+	}
 	return int(bytesDownloaded), nil
 }
 
+// ReadAt reads len(p) bytes starting at off with a single ranged GET. It
+// does not use the offset of Seek and is safe for concurrent use, io.EOF is
+// returned when p could not be filled.
+func (s *S3File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Wrap(errInvalidOffset, "errInvalidOffset")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.whole != nil {
+		return s.readWholeAt(p, off)
+	}
+
+	end := off + int64(len(p)) - 1
+	if s.fileSize > 0 {
+		if off >= s.fileSize {
+			return 0, errors.Wrap(io.EOF, "io.EOF")
+		}
+		if end >= s.fileSize {
+			end = s.fileSize - 1
+		}
+	}
+	getObj := s.getObjectInput()
+	getObj.Range = aws.String(fmt.Sprintf(rangeHeader, off, end))
+
+	ctx, span := trace.Start(s.ctx, "s3.GetObject", objectAttributes(getObj.Bucket, getObj.Key, trace.String(trace.AttrRange, *getObj.Range))...)
+	wab := manager.NewWriteAtBuffer(p[:end-off+1])
+	bytesDownloaded, err := s.downloader.Download(ctx, wab, getObj)
+	span.SetAttributes(trace.Int64(trace.AttrBytes, bytesDownloaded))
+	span.End(err)
+	if err != nil {
+		return 0, downloadError(err)
+	}
+
+	n := int(bytesDownloaded)
+	if n < len(p) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// getObjectInput returns the input of a GetObject request for the version
+// and ETag of s
+func (s *S3File) getObjectInput() *s3.GetObjectInput {
+	getObj := &s3.GetObjectInput{
+		Bucket:    aws.String(s.BucketName),
+		Key:       aws.String(s.Key),
+		VersionId: s.VersionId,
+	}
+	if s.ETag != "" {
+		getObj.IfMatch = aws.String(s.ETag)
+	}
+	return getObj
+}
+
+// downloadError wraps an error of the downloader, a failed If-Match means
+// the object was overwritten
+func downloadError(err error) error {
+	if preconditionFailed(err) {
+		return errors.Wrapf(ErrObjectChanged, "s.downloader.Download: %v", err)
+	}
+	return errors.Wrap(err, "s.downloader.Download")
+}
+
+// preconditionFailed reports whether err is a failed If-Match or
+// If-None-Match
+func preconditionFailed(err error) bool {
+	var apiErr interface{ ErrorCode() string }
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// Size returns the object size reported by HeadObject, 0 if unknown
+func (s *S3File) Size() int64 {
+	return s.fileSize
+}
+
+// CacheKey returns the bucket, key and version s was opened on, as used by
+// the diskcache package. The version is empty when the ETag is unknown.
+// ReadAt only reads that version.
+func (s *S3File) CacheKey() (backend, bucket, object, version string) {
+	version = s.ETag
+	if s.VersionId != nil && s.ETag != "" {
+		version = *s.VersionId + "/" + s.ETag
+	}
+	return "s3", s.BucketName, s.Key, version
+}
+
 // Write len(p) bytes from p to the S3 data stream
 func (s *S3File) Write(p []byte) (n int, err error) {
 	s.lock.RLock()
@@ -231,28 +406,114 @@ func (s *S3File) Write(p []byte) (n int, err error) {
 
 // Close signals write completion and cleans up any
 // open streams. Will block until pending uploads are complete.
+// The upload is aborted instead when the context of s was canceled.
+// Close returns ErrAborted, or the error of an upload that had already
+// failed, after Abort. Later calls return the result of the first one.
 func (s *S3File) Close() error {
 	var err error
 
+	s.lock.RLock()
+	closed, closeErr := s.closed, s.closeErr
+	aborted := s.err == ErrAborted
+	s.lock.RUnlock()
+	if closed {
+		return closeErr
+	}
+	if aborted {
+		return ErrAborted
+	}
+
 	if s.pipeWriter != nil {
-		if err = s.pipeWriter.Close(); err != nil {
+		if s.ctx != nil && s.ctx.Err() != nil {
+			s.pipeWriter.CloseWithError(s.ctx.Err())
+		} else if err = s.pipeWriter.Close(); err != nil {
 			return errors.Wrap(err, "s.pipeWriter.Close")
 		}
 	}
 
-	// wait for pending uploads
+	// wait for pending uploads, once
 	if s.writeDone != nil {
+		_, span := trace.Start(s.ctx, "s3.Close", objectAttributes(&s.BucketName, &s.Key)...)
 		err = <-s.writeDone
+		span.End(err)
+		if err != nil {
+			err = errors.Wrap(err, "<-s.writeDone")
+		}
+
+		s.lock.Lock()
+		s.closed = true
+		s.closeErr = err
+		s.lock.Unlock()
 	}
+	return err
+}
 
-	if err != nil {
-		return errors.Wrap(err, "<-s.writeDone")
+// Abort stops the upload without creating the object, the parts of a
+// multipart upload are deleted. It blocks until the uploader returns and
+// has no effect after Close. Close then returns ErrAborted, or the error of
+// an upload that failed before Abort.
+func (s *S3File) Abort() {
+	s.lock.Lock()
+	if s.pipeWriter == nil || s.closed {
+		s.lock.Unlock()
+		return
+	}
+	failed := s.err != nil
+	if !failed {
+		s.err = ErrAborted
+	}
+	s.lock.Unlock()
+	s.pipeWriter.CloseWithError(ErrAborted)
+
+	if s.writeDone != nil {
+		// the upload fails with ErrAborted, unless it failed before
+		_, span := trace.Start(s.ctx, "s3.Abort", objectAttributes(&s.BucketName, &s.Key)...)
+		err := <-s.writeDone
+		span.End(nil)
+		if failed && err != nil {
+			err = errors.Wrap(err, "<-s.writeDone")
+		} else {
+			err = ErrAborted
+		}
+
+		s.lock.Lock()
+		s.closed = true
+		s.closeErr = err
+		s.lock.Unlock()
 	}
-	return nil
 }
 
-// Open creates a new S3 File instance to perform concurrent reads
+// Open creates a new S3 File instance to perform concurrent reads. Instances
+// of the same key read the same version and ETag as s.
 func (s *S3File) Open(name string) (source.ParquetFile, error) {
+	// ColumBuffer passes in an empty string for name
+	if len(name) == 0 {
+		name = s.Key
+	}
+
+	// files opened from a writer have no downloader yet
+	downloader := s.downloader
+	if downloader == nil {
+		downloader = manager.NewDownloader(s.client)
+	}
+
+	if name != s.Key {
+		// another object, with its own size and ETag
+		pf := &S3File{
+			ctx:            s.ctx,
+			client:         s.client,
+			downloader:     downloader,
+			BucketName:     s.BucketName,
+			Key:            name,
+			verifyChecksum: s.verifyChecksum,
+			wholeThreshold: s.wholeThreshold,
+		}
+		if err := pf.openRead(); err != nil {
+			return nil, errors.Wrap(err, "pf.openRead")
+		}
+		return pf, nil
+	}
+
 	s.lock.RLock()
 	readOpened := s.readOpened
 	s.lock.RUnlock()
@@ -262,21 +523,22 @@ func (s *S3File) Open(name string) (source.ParquetFile, error) {
 		}
 	}
 
-	// ColumBuffer passes in an empty string for name
-	if len(name) == 0 {
-		name = s.Key
-	}
-
 	// create a new instance
 	pf := &S3File{
-		ctx:        s.ctx,
-		client:     s.client,
-		downloader: s.downloader,
-		BucketName: s.BucketName,
-		Key:        name,
-		readOpened: s.readOpened,
-		fileSize:   s.fileSize,
-		offset:     0,
+		ctx:            s.ctx,
+		client:         s.client,
+		downloader:     downloader,
+		BucketName:     s.BucketName,
+		Key:            name,
+		VersionId:      s.VersionId,
+		ETag:           s.ETag,
+		readOpened:     s.readOpened,
+		fileSize:       s.fileSize,
+		stored:         s.stored,
+		verifyChecksum: s.verifyChecksum,
+		wholeThreshold: s.wholeThreshold,
+		whole:          s.whole,
+		offset:         0,
 	}
 	return pf, nil
 }
@@ -284,12 +546,15 @@ func (s *S3File) Open(name string) (source.ParquetFile, error) {
 // Create creates a new S3 File instance to perform writes
 func (s *S3File) Create(key string) (source.ParquetFile, error) {
 	pf := &S3File{
-		ctx:             s.ctx,
-		client:          s.client,
-		uploaderOptions: s.uploaderOptions,
-		BucketName:      s.BucketName,
-		Key:             key,
-		writeDone:       make(chan error),
+		ctx:               s.ctx,
+		client:            s.client,
+		uploaderOptions:   s.uploaderOptions,
+		putOptions:        s.putOptions,
+		noOverwrite:       s.noOverwrite,
+		checksumAlgorithm: s.checksumAlgorithm,
+		BucketName:        s.BucketName,
+		Key:               key,
+		writeDone:         make(chan error),
 	}
 	pf.openWrite()
 	return pf, nil
@@ -299,29 +564,56 @@ func (s *S3File) Create(key string) (source.ParquetFile, error) {
 // Calling Close signals write completion.
 func (s *S3File) openWrite() {
 	pr, pw := io.Pipe()
-	uploader := manager.NewUploader(s.client, s.uploaderOptions...)
+	uploadParams := &s3.PutObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.Key),
+		Body:   pr,
+	}
+	for _, fn := range s.putOptions {
+		fn(uploadParams)
+	}
+
+	client := s.client
+	if uploadParams.SSECustomerKeyMD5 != nil {
+		client = customerKeyClient{S3API: client, keyMD5: uploadParams.SSECustomerKeyMD5}
+	}
+	if s.noOverwrite {
+		client = noOverwriteClient{client}
+	}
+	var parts *checksum.Parts
+	if s.checksumAlgorithm != "" {
+		parts = &checksum.Parts{}
+		client = checksumClient{S3API: client, parts: parts, algorithm: s.checksumAlgorithm}
+	}
+	uploader := manager.NewUploader(abortClient{tracedClient{client}}, s.uploaderOptions...)
 	s.lock.Lock()
+	s.parts = parts
 	s.pipeReader = pr
 	s.pipeWriter = pw
 	s.writeOpened = true
 	s.uploader = uploader
 	s.lock.Unlock()
 
-	uploadParams := &s3.PutObjectInput{
-		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(s.Key),
-		Body:   s.pipeReader,
-	}
+	stop := make(chan struct{})
+	go s.abortOnCancel(pw, stop)
 
 	go func(uploader *manager.Uploader, params *s3.PutObjectInput, done chan error) {
 		defer close(done)
 
 		// upload data and signal done when complete
 		_, err := uploader.Upload(s.ctx, params)
-		if err != nil {
+		close(stop)
+		if err != nil && s.noOverwrite && preconditionFailed(err) {
+			err = errors.Wrapf(ErrObjectExists, "uploader.Upload: %v", err)
+		} else if err != nil {
 			err = errors.Wrap(err, "uploader.Upload")
+		}
+		if err != nil {
 			s.lock.Lock()
-			s.err = err
+			// keep ErrAborted when the upload failed because of Abort
+			if s.err == nil {
+				s.err = err
+			}
 			s.lock.Unlock()
 
 			if s.writeOpened {
@@ -333,26 +625,76 @@ func (s *S3File) openWrite() {
 	}(s.uploader, uploadParams, s.writeDone)
 }
 
+// abortClient aborts multipart uploads with a context that is never
+// canceled. The uploader aborts with its own context, which may be the
+// canceled context that made the upload fail.
+type abortClient struct {
+	S3API
+}
+
+func (c abortClient) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return c.S3API.AbortMultipartUpload(sourceutil.Detach(ctx), in, optFns...)
+}
+
+// abortOnCancel ends the body of the upload with the error of the context
+// once it is canceled, so that the uploader aborts the upload instead of
+// waiting for more data
+func (s *S3File) abortOnCancel(pw *io.PipeWriter, stop chan struct{}) {
+	select {
+	case <-s.ctx.Done():
+		pw.CloseWithError(s.ctx.Err())
+	case <-stop:
+	}
+}
+
 // openRead verifies the requested file is accessible and
 // tracks the file size
 func (s *S3File) openRead() error {
 	hoi := &s3.HeadObjectInput{
-		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(s.Key),
+		Bucket:    aws.String(s.BucketName),
+		Key:       aws.String(s.Key),
+		VersionId: s.VersionId,
+	}
+
+	var (
+		optFns []func(*s3.Options)
+		header http.Header
+	)
+	if s.verifyChecksum {
+		optFns = append(optFns, checksumMode, responseHeader(&header))
 	}
 
-	hoo, err := s.client.HeadObject(s.ctx, hoi)
+	ctx, span := trace.Start(s.ctx, "s3.HeadObject", objectAttributes(hoi.Bucket, hoi.Key)...)
+	hoo, err := s.client.HeadObject(ctx, hoi, optFns...)
+	span.End(err)
 	if err != nil {
 		return errors.Wrap(err, "s.client.HeadObject")
 	}
 
+	var stored checksum.Sum
+	if s.verifyChecksum {
+		if stored, err = storedChecksum(hoo, header); err != nil {
+			return errors.Wrap(err, "storedChecksum")
+		}
+	}
+
 	s.lock.Lock()
 	s.readOpened = true
 	if hoo.ContentLength != 0 {
 		s.fileSize = hoo.ContentLength
 	}
+	if hoo.ETag != nil {
+		s.ETag = *hoo.ETag
+	}
+	s.stored = stored
 	s.lock.Unlock()
 
+	// small objects are read with a single request
+	if s.fileSize > 0 && s.fileSize <= s.wholeThreshold {
+		if err = s.download(); err != nil {
+			return errors.Wrap(err, "s.download")
+		}
+	}
 	return nil
 }
 
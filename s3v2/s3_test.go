@@ -3,6 +3,7 @@ package s3v2
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
@@ -12,7 +13,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/golang/mock/gomock"
 	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go-source/s3test"
 	"github.com/sabey/parquet-go-source/s3v2/mocks"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go-source/trace"
+	"github.com/sabey/parquet-go-source/trace/tracetest"
+	"github.com/sabey/parquet-go/source"
 )
 
 func TestSeek(t *testing.T) {
@@ -32,8 +38,10 @@ func TestSeek(t *testing.T) {
 		{"seek start read past end", 20, 0, 21, io.SeekStart, 0, errInvalidOffset},
 		{"seek current", 20, 5, 5, io.SeekCurrent, 10, nil},
 		{"seek current read past end", 20, 10, 20, io.SeekCurrent, 0, errInvalidOffset},
-		{"seek end", 20, 10, -5, io.SeekEnd, -5, nil},
+		{"seek end", 20, 10, -5, io.SeekEnd, 15, nil},
+		{"seek end to end", 20, 10, 0, io.SeekEnd, 20, nil},
 		{"seek end read past beginning", 20, 0, -30, io.SeekEnd, 0, errInvalidOffset},
+		{"seek end past end", 20, 0, 1, io.SeekEnd, 0, errInvalidOffset},
 		{"invalid whence", 20, 0, 0, 6, 0, errWhence},
 	}
 
@@ -514,3 +522,381 @@ func TestGetBytesRange(t *testing.T) {
 		})
 	}
 }
+
+func newFakeS3(t *testing.T) (*s3test.Server, func(t *testing.T) string) {
+	server := s3test.NewServer()
+	t.Cleanup(server.Close)
+	server.CreateBucket("test-bucket")
+
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("conformance/%d.parquet", files)
+	}
+	return server, newName
+}
+
+func TestConformance(t *testing.T) {
+	server, newName := newFakeS3(t)
+	client := server.ClientV2()
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewReader: func(t *testing.T, data []byte) source.ParquetFile {
+			key := newName(t)
+			server.PutObject("test-bucket", key, data)
+			pf, err := NewS3FileReaderWithClient(context.Background(), client, "test-bucket", key)
+			if err != nil {
+				t.Fatalf("NewS3FileReaderWithClient: %v", err)
+			}
+			return pf
+		},
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", newName(t), nil)
+			if err != nil {
+				t.Fatalf("NewS3FileWriterWithClient: %v", err)
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
+
+func TestSuite(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+
+	s3test.Run(t, s3test.Harness{
+		Server: server,
+		Bucket: "test-bucket",
+		NewWholeReader: func(t *testing.T, key string, threshold int64) (source.ParquetFile, bool) {
+			pf, err := NewS3FileReaderWithClient(context.Background(), client, "test-bucket", key, WithWholeObjectThreshold(threshold))
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf, pf.(*S3File).whole != nil
+		},
+		OpenVerifiedReader: func(key string) (source.ParquetFile, error) {
+			return NewS3FileReaderWithClient(context.Background(), client, "test-bucket", key, WithVerifyChecksum())
+		},
+		Verified: func(pf source.ParquetFile) string {
+			if pf.(*S3File).verifier == nil {
+				return ""
+			}
+			return pf.(*S3File).stored.Algorithm
+		},
+		ErrChecksumMismatch:    ErrChecksumMismatch,
+		ErrChecksumUnavailable: ErrChecksumUnavailable,
+		NewWriter: func(t *testing.T, key string, algorithm string) source.ParquetFile {
+			pf, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", key, nil, WithChecksumAlgorithm(algorithm))
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+		Checksums: func(pf source.ParquetFile) s3test.Checksums {
+			return s3test.Checksums(pf.(*S3File).Checksums())
+		},
+		Abort: func(pf source.ParquetFile) {
+			pf.(*S3File).Abort()
+		},
+	})
+}
+
+func TestMultipartRoundTrip(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+	data := make([]byte, 11*1024*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	fw, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", "multipart.parquet", nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	for i := 0; i < len(data); i += 1024 * 1024 {
+		if _, err = fw.Write(data[i : i+1024*1024]); err != nil {
+			t.Fatalf("expected error to be nil but got %q", err.Error())
+		}
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if n := server.Uploads(); n != 0 {
+		t.Errorf("expected no pending uploads but got %d", n)
+	}
+
+	fr, err := NewS3FileReaderWithClient(context.Background(), client, "test-bucket", "multipart.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fr.Seek(5*1024*1024-10, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	b := make([]byte, 20)
+	if _, err = fr.Read(b); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if !bytes.Equal(b, data[5*1024*1024-10:5*1024*1024+10]) {
+		t.Errorf("expected data across the part boundary to match")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewRecorder()
+	trace.SetTracer(recorder)
+	defer trace.SetTracer(nil)
+
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+	data := make([]byte, 6*1024*1024)
+
+	fw, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", "traced.parquet", nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fw.Write(data); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err = fw.Close(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	fr, err := NewS3FileReaderWithClient(context.Background(), client, "test-bucket", "traced.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fr.Seek(10, io.SeekStart); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if _, err = fr.Read(make([]byte, 20)); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+
+	counts := map[string]int{}
+	for _, span := range recorder.Spans() {
+		counts[span.Name]++
+		if span.Err != nil {
+			t.Errorf("expected span %s to succeed but got %v", span.Name, span.Err)
+		}
+		if span.Attributes[trace.AttrBucket] != "test-bucket" || span.Attributes[trace.AttrKey] != "traced.parquet" {
+			t.Errorf("expected span %s to carry the bucket and key but got %v", span.Name, span.Attributes)
+		}
+		if span.Name == "s3.GetObject" {
+			if span.Attributes[trace.AttrRange] != "bytes=10-29" || span.Attributes[trace.AttrBytes] != int64(20) {
+				t.Errorf("expected the GetObject span to carry the range and size but got %v", span.Attributes)
+			}
+		}
+	}
+	expected := map[string]int{
+		"s3.CreateMultipartUpload":   1,
+		"s3.UploadPart":              2,
+		"s3.CompleteMultipartUpload": 1,
+		"s3.Close":                   1,
+		"s3.HeadObject":              1,
+		"s3.GetObject":               1,
+	}
+	for name, n := range expected {
+		if counts[name] != n {
+			t.Errorf("expected %d %s spans but got %d", n, name, counts[name])
+		}
+	}
+}
+
+func TestReadVersioned(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+	server.PutObject("test-bucket", "versioned.parquet", []byte("version one"))
+	version, _ := server.Version("test-bucket", "versioned.parquet")
+	server.PutObject("test-bucket", "versioned.parquet", []byte("version two!"))
+
+	for _, test := range []struct {
+		name     string
+		version  *string
+		opts     []Option
+		expected string
+	}{
+		{"current", nil, nil, "version two!"},
+		{"version", &version, nil, "version one"},
+		{"WithVersionID", nil, []Option{WithVersionID(version)}, "version one"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var fr source.ParquetFile
+			var err error
+			if test.version != nil {
+				fr, err = NewS3FileReaderVersionedWithClient(context.Background(), client, "test-bucket", "versioned.parquet", test.version, test.opts...)
+			} else {
+				fr, err = NewS3FileReaderWithClient(context.Background(), client, "test-bucket", "versioned.parquet", test.opts...)
+			}
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			// column readers use their own handle
+			if fr, err = fr.Open(""); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			b := make([]byte, len(test.expected))
+			if _, err = fr.Read(b); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if string(b) != test.expected {
+				t.Errorf("expected %q but got %q", test.expected, b)
+			}
+		})
+	}
+}
+
+func TestReadObjectChanged(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+	server.PutObject("test-bucket", "changed.parquet", []byte("version one"))
+
+	fr, err := NewS3FileReaderWithClient(context.Background(), client, "test-bucket", "changed.parquet")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	handle, err := fr.Open("")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	server.PutObject("test-bucket", "changed.parquet", []byte("version two"))
+
+	b := make([]byte, 4)
+	if _, err = fr.Read(b); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("expected error to be %v but got %v", ErrObjectChanged, err)
+	}
+	if _, err = handle.(*S3File).ReadAt(b, 4); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("expected error to be %v but got %v", ErrObjectChanged, err)
+	}
+	for _, req := range server.Requests() {
+		if req.Method == "GET" && req.Header.Get("If-Match") == "" {
+			t.Errorf("expected every GET to carry If-Match")
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+
+	for _, test := range []struct {
+		name   string
+		size   int
+		cancel bool
+	}{
+		{"put", 100, false},
+		{"multipart", 6 * 1024 * 1024, false},
+		{"put canceled", 100, true},
+		{"multipart canceled", 6 * 1024 * 1024, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			key := strings.Replace(test.name, " ", "-", -1) + ".parquet"
+			fw, err := NewS3FileWriterWithClient(ctx, client, "test-bucket", key, nil)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err = fw.Write(make([]byte, test.size)); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+
+			if test.cancel {
+				cancel()
+				if err = fw.Close(); !errors.Is(err, context.Canceled) {
+					t.Errorf("expected error to be %v but got %v", context.Canceled, err)
+				}
+			} else {
+				fw.(*S3File).Abort()
+				if _, err = fw.Write([]byte("more")); !errors.Is(err, ErrAborted) {
+					t.Errorf("expected error to be %v but got %v", ErrAborted, err)
+				}
+				if err = fw.Close(); !errors.Is(err, ErrAborted) {
+					t.Errorf("expected error to be %v but got %v", ErrAborted, err)
+				}
+			}
+
+			if _, ok := server.Object("test-bucket", key); ok {
+				t.Errorf("expected no object to be created")
+			}
+			if n := server.Uploads(); n != 0 {
+				t.Errorf("expected no pending uploads but got %d", n)
+			}
+		})
+	}
+}
+
+func TestAbortAfterClose(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+	server.PutObject("test-bucket", "existing.parquet", []byte("existing"))
+
+	for _, test := range []struct {
+		name     string
+		key      string
+		expected error
+	}{
+		{"closed", "closed.parquet", nil},
+		{"close failed", "existing.parquet", ErrObjectExists},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fw, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", test.key, nil, WithNoOverwrite())
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if _, err = fw.Write([]byte("data")); err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			if err = fw.Close(); !errors.Is(err, test.expected) {
+				t.Fatalf("expected error to be %v but got %v", test.expected, err)
+			}
+
+			// Abort has no effect and Close keeps returning its first result
+			fw.(*S3File).Abort()
+			for i := 0; i < 2; i++ {
+				if err = fw.Close(); !errors.Is(err, test.expected) {
+					t.Errorf("expected error to be %v but got %v", test.expected, err)
+				}
+			}
+			if data, ok := server.Object("test-bucket", test.key); !ok || (test.expected == nil && string(data) != "data") {
+				t.Errorf("expected the object to be kept")
+			}
+		})
+	}
+}
+
+func TestNoOverwrite(t *testing.T) {
+	server, _ := newFakeS3(t)
+	client := server.ClientV2()
+
+	for _, test := range []struct {
+		name string
+		size int
+	}{
+		{"put", 100},
+		{"multipart", 6 * 1024 * 1024},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			key := test.name + "-no-overwrite.parquet"
+			for i, expected := range []error{nil, ErrObjectExists} {
+				fw, err := NewS3FileWriterWithClient(context.Background(), client, "test-bucket", key, nil, WithNoOverwrite())
+				if err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if _, err = fw.Write(bytes.Repeat([]byte{byte(i)}, test.size)); err != nil {
+					t.Fatalf("expected error to be nil but got %q", err.Error())
+				}
+				if err = fw.Close(); !errors.Is(err, expected) {
+					t.Errorf("writer %d: expected error to be %v but got %v", i, expected, err)
+				}
+			}
+
+			if data, _ := server.Object("test-bucket", key); len(data) != test.size || data[0] != 0 {
+				t.Errorf("expected the first object to be kept")
+			}
+			if n := server.Uploads(); n != 0 {
+				t.Errorf("expected no pending uploads but got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package s3v2
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+// objectAttributes returns the span attributes identifying an object
+func objectAttributes(bucket *string, key *string, attrs ...trace.Attribute) []trace.Attribute {
+	return append([]trace.Attribute{
+		trace.String(trace.AttrBackend, "s3"),
+		trace.String(trace.AttrBucket, aws.ToString(bucket)),
+		trace.String(trace.AttrKey, aws.ToString(key)),
+	}, attrs...)
+}
+
+// tracedClient starts a span around each request the uploader makes, so
+// that every part of a multipart upload is traced
+type tracedClient struct {
+	S3API
+}
+
+func (c tracedClient) PutObject(ctx context.Context, in *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.PutObject", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.PutObject(ctx, in, optFns...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.UploadPart", objectAttributes(in.Bucket, in.Key, trace.Int64(trace.AttrPart, int64(in.PartNumber)))...)
+	if in.ContentLength > 0 {
+		span.SetAttributes(trace.Int64(trace.AttrBytes, in.ContentLength))
+	}
+	out, err := c.S3API.UploadPart(ctx, in, optFns...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) CreateMultipartUpload(ctx context.Context, in *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.CreateMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.CreateMultipartUpload(ctx, in, optFns...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) CompleteMultipartUpload(ctx context.Context, in *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.CompleteMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.CompleteMultipartUpload(ctx, in, optFns...)
+	span.End(err)
+	return out, err
+}
+
+func (c tracedClient) AbortMultipartUpload(ctx context.Context, in *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	ctx, span := trace.Start(ctx, "s3.AbortMultipartUpload", objectAttributes(in.Bucket, in.Key)...)
+	out, err := c.S3API.AbortMultipartUpload(ctx, in, optFns...)
+	span.End(err)
+	return out, err
+}
@@ -0,0 +1,457 @@
+// Package sourcetest implements a conformance suite for source.ParquetFile
+// implementations.
+//
+// The contract checked by Run is:
+//   - Seek accepts io.SeekStart, io.SeekCurrent and io.SeekEnd, returns the
+//     new offset from the start of the file, rejects any other whence and
+//     rejects offsets before the start of the file. Seeking past the end
+//     either fails or leaves the file at EOF.
+//   - Read fills p completely unless the end of the file is reached; a Read
+//     that fills p returns a nil error and a Read at the end of the file
+//     returns 0 and an error matching io.EOF (errors.Is).
+//   - Sources implementing io.ReaderAt follow the Read rules for ReadAt,
+//     leave the offset used by Read untouched and allow concurrent calls.
+//   - Open("") returns an independent handle on the same file.
+//   - Create returns a new handle whose contents can be re-opened after Close.
+//   - Close may be called more than once.
+//   - A parquet file written through the source can be read back.
+package sourcetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sabey/parquet-go/reader"
+	"github.com/sabey/parquet-go/source"
+	"github.com/sabey/parquet-go/writer"
+)
+
+// Harness describes how to obtain ParquetFiles from the implementation under test
+type Harness struct {
+	// NewReader returns a ParquetFile positioned at the start of data. When
+	// nil, data is written through NewWriter and re-opened with Open("").
+	NewReader func(t *testing.T, data []byte) source.ParquetFile
+	// NewWriter returns an empty ParquetFile ready for writing. Once closed,
+	// Open("") on it must read back what was written. Leave nil for read-only
+	// sources.
+	NewWriter func(t *testing.T) source.ParquetFile
+	// NewName returns a fresh name that Create accepts on the files returned
+	// by NewWriter. The Create test is skipped when nil.
+	NewName func(t *testing.T) string
+}
+
+// Student is the row type used for the parquet round trip
+type Student struct {
+	Name   string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Age    int32   `parquet:"name=age, type=INT32"`
+	ID     int64   `parquet:"name=id, type=INT64"`
+	Weight float32 `parquet:"name=weight, type=FLOAT"`
+	Sex    bool    `parquet:"name=sex, type=BOOLEAN"`
+}
+
+const dataSize = 1000
+
+// Data returns the deterministic content used by the suite
+func Data() []byte {
+	data := make([]byte, dataSize)
+	for i := range data {
+		data[i] = byte(i*7 + i/256)
+	}
+	return data
+}
+
+// Run exercises every part of the ParquetFile contract against h
+func Run(t *testing.T, h Harness) {
+	if h.NewReader == nil && h.NewWriter == nil {
+		t.Fatal("sourcetest: harness needs NewReader or NewWriter")
+	}
+
+	t.Run("Seek", func(t *testing.T) { testSeek(t, h) })
+	t.Run("SeekOutOfRange", func(t *testing.T) { testSeekOutOfRange(t, h) })
+	t.Run("Read", func(t *testing.T) { testRead(t, h) })
+	t.Run("ReadEOF", func(t *testing.T) { testReadEOF(t, h) })
+	t.Run("ReadAt", func(t *testing.T) { testReadAt(t, h) })
+	t.Run("OpenReuse", func(t *testing.T) { testOpenReuse(t, h) })
+	t.Run("CloseReader", func(t *testing.T) { testCloseReader(t, h) })
+	if h.NewWriter == nil {
+		return
+	}
+	t.Run("CloseWriter", func(t *testing.T) { testCloseWriter(t, h) })
+	if h.NewName != nil {
+		t.Run("Create", func(t *testing.T) { testCreate(t, h) })
+	}
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, h) })
+}
+
+func newReader(t *testing.T, h Harness, data []byte) source.ParquetFile {
+	t.Helper()
+	if h.NewReader != nil {
+		return h.NewReader(t, data)
+	}
+
+	pf := h.NewWriter(t)
+	if _, err := pf.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	r, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return r
+}
+
+func closeFile(t *testing.T, pf source.ParquetFile) {
+	t.Helper()
+	if err := pf.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+// readAt seeks to offset and expects the next Read to return want
+func readAt(t *testing.T, pf source.ParquetFile, offset int64, whence int, want []byte) {
+	t.Helper()
+	if _, err := pf.Seek(offset, whence); err != nil {
+		t.Fatalf("Seek(%d, %d): %v", offset, whence, err)
+	}
+	expectRead(t, pf, want)
+}
+
+func expectRead(t *testing.T, pf source.ParquetFile, want []byte) {
+	t.Helper()
+	b := make([]byte, len(want))
+	n, err := pf.Read(b)
+	if err != nil {
+		t.Fatalf("Read(%d bytes): %v", len(b), err)
+	}
+	if n != len(want) {
+		t.Fatalf("expected Read to return %d bytes but got %d", len(want), n)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("expected Read to return %v but got %v", want, b)
+	}
+}
+
+func testSeek(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	offset, err := pf.Seek(100, io.SeekStart)
+	if err != nil {
+		t.Fatalf("Seek(100, io.SeekStart): %v", err)
+	}
+	if offset != 100 {
+		t.Errorf("expected Seek to return 100 but got %d", offset)
+	}
+	expectRead(t, pf, data[100:150])
+
+	offset, err = pf.Seek(50, io.SeekCurrent)
+	if err != nil {
+		t.Fatalf("Seek(50, io.SeekCurrent): %v", err)
+	}
+	if offset != 200 {
+		t.Errorf("expected Seek to return 200 but got %d", offset)
+	}
+	expectRead(t, pf, data[200:210])
+
+	offset, err = pf.Seek(-20, io.SeekCurrent)
+	if err != nil {
+		t.Fatalf("Seek(-20, io.SeekCurrent): %v", err)
+	}
+	if offset != 190 {
+		t.Errorf("expected Seek to return 190 but got %d", offset)
+	}
+	expectRead(t, pf, data[190:200])
+
+	offset, err = pf.Seek(-8, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("Seek(-8, io.SeekEnd): %v", err)
+	}
+	if offset != dataSize-8 {
+		t.Errorf("expected Seek to return %d but got %d", dataSize-8, offset)
+	}
+	expectRead(t, pf, data[dataSize-8:])
+	readAt(t, pf, -int64(dataSize), io.SeekEnd, data[:16])
+	readAt(t, pf, 0, io.SeekStart, data[:dataSize])
+}
+
+func testSeekOutOfRange(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	if _, err := pf.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected Seek(-1, io.SeekStart) to fail")
+	}
+	if _, err := pf.Seek(-1, io.SeekCurrent); err == nil {
+		t.Error("expected Seek(-1, io.SeekCurrent) to fail at the start of the file")
+	}
+	if _, err := pf.Seek(-dataSize-1, io.SeekEnd); err == nil {
+		t.Error("expected Seek before the start of the file to fail")
+	}
+	if _, err := pf.Seek(0, 42); err == nil {
+		t.Error("expected Seek with an invalid whence to fail")
+	}
+
+	// the file must still be usable after a rejected seek
+	readAt(t, pf, 10, io.SeekStart, data[10:20])
+
+	if _, err := pf.Seek(dataSize+10, io.SeekStart); err == nil {
+		n, err := pf.Read(make([]byte, 10))
+		if n != 0 || !errors.Is(err, io.EOF) {
+			t.Errorf("expected Read past the end to return 0, io.EOF but got %d, %v", n, err)
+		}
+	}
+}
+
+func testRead(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	// sequential reads continue where the previous one stopped
+	expectRead(t, pf, data[:1])
+	expectRead(t, pf, data[1:300])
+	expectRead(t, pf, data[300:dataSize])
+
+	// a buffer larger than the remainder returns what is left
+	if _, err := pf.Seek(dataSize-10, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	b := make([]byte, 100)
+	n, err := pf.Read(b)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("expected a short Read to return nil or io.EOF but got %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("expected short Read to return 10 bytes but got %d", n)
+	}
+	if !bytes.Equal(b[:n], data[dataSize-10:]) {
+		t.Errorf("expected Read to return %v but got %v", data[dataSize-10:], b[:n])
+	}
+}
+
+func testReadEOF(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	// reading exactly up to the end is not an error
+	readAt(t, pf, dataSize-10, io.SeekStart, data[dataSize-10:])
+
+	for i := 0; i < 2; i++ {
+		n, err := pf.Read(make([]byte, 10))
+		if n != 0 {
+			t.Errorf("expected Read at EOF to return 0 bytes but got %d", n)
+		}
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("expected Read at EOF to return io.EOF but got %v", err)
+		}
+	}
+}
+
+func testReadAt(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	ra, ok := pf.(io.ReaderAt)
+	if !ok {
+		t.Skip("source does not implement io.ReaderAt")
+	}
+	if _, err := pf.Seek(100, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, dataSize/100)
+	for off := 0; off < dataSize; off += 100 {
+		wg.Add(1)
+		go func(off int) {
+			defer wg.Done()
+			b := make([]byte, 100)
+			n, err := ra.ReadAt(b, int64(off))
+			if err != nil {
+				errs <- fmt.Errorf("ReadAt(100 bytes, %d): %v", off, err)
+				return
+			}
+			if n != len(b) || !bytes.Equal(b, data[off:off+100]) {
+				errs <- fmt.Errorf("expected ReadAt at %d to return %v but got %v", off, data[off:off+100], b[:n])
+			}
+		}(off)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	b := make([]byte, 20)
+	n, err := ra.ReadAt(b, dataSize-10)
+	if n != 10 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected a short ReadAt to return 10 bytes and io.EOF but got %d and %v", n, err)
+	}
+	if !bytes.Equal(b[:n], data[dataSize-10:]) {
+		t.Errorf("expected a short ReadAt to return %v but got %v", data[dataSize-10:], b[:n])
+	}
+	n, err = ra.ReadAt(b, dataSize)
+	if n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected ReadAt at the end to return 0 bytes and io.EOF but got %d and %v", n, err)
+	}
+	if _, err = ra.ReadAt(b, -1); err == nil {
+		t.Error("expected ReadAt at a negative offset to fail")
+	}
+
+	// ReadAt does not move the offset used by Read
+	expectRead(t, pf, data[100:110])
+}
+
+func testOpenReuse(t *testing.T, h Harness) {
+	data := Data()
+	pf := newReader(t, h, data)
+	defer closeFile(t, pf)
+
+	readAt(t, pf, 500, io.SeekStart, data[500:510])
+
+	a, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeFile(t, a)
+	b, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeFile(t, b)
+
+	// every handle keeps its own position
+	readAt(t, a, 0, io.SeekStart, data[:10])
+	readAt(t, b, 700, io.SeekStart, data[700:710])
+	expectRead(t, a, data[10:20])
+	expectRead(t, b, data[710:720])
+	expectRead(t, pf, data[510:520])
+
+	// closing a child does not affect its siblings
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	expectRead(t, b, data[720:730])
+	expectRead(t, pf, data[520:530])
+}
+
+func testCloseReader(t *testing.T, h Harness) {
+	pf := newReader(t, h, Data())
+	for i := 0; i < 2; i++ {
+		if err := pf.Close(); err != nil {
+			t.Errorf("expected Close #%d to succeed but got %v", i+1, err)
+		}
+	}
+}
+
+func testCloseWriter(t *testing.T, h Harness) {
+	pf := h.NewWriter(t)
+	if _, err := pf.Write(Data()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := pf.Close(); err != nil {
+			t.Errorf("expected Close #%d to succeed but got %v", i+1, err)
+		}
+	}
+}
+
+func testCreate(t *testing.T, h Harness) {
+	data := Data()
+	pf := h.NewWriter(t)
+	defer closeFile(t, pf)
+
+	c, err := pf.Create(h.NewName(t))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if c == pf {
+		t.Fatal("expected Create to return a new handle")
+	}
+	for i := 0; i < dataSize; i += 100 {
+		if _, err := c.Write(data[i : i+100]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := c.Open("")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeFile(t, r)
+	expectRead(t, r, data)
+}
+
+func testRoundTrip(t *testing.T, h Harness) {
+	const numRows = 1000
+
+	pf := h.NewWriter(t)
+	pw, err := writer.NewParquetWriter(pf, new(Student), 2)
+	if err != nil {
+		t.Fatalf("writer.NewParquetWriter: %v", err)
+	}
+	pw.RowGroupSize = 16 * 1024
+	pw.PageSize = 1024
+
+	want := make([]Student, numRows)
+	for i := range want {
+		want[i] = Student{
+			Name:   "StudentName",
+			Age:    int32(20 + i%5),
+			ID:     int64(i),
+			Weight: float32(50.0 + float32(i)*0.1),
+			Sex:    i%2 == 0,
+		}
+		if err := pw.Write(want[i]); err != nil {
+			t.Fatalf("pw.Write: %v", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatalf("pw.WriteStop: %v", err)
+	}
+	if err := pf.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := pf.Open("")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer closeFile(t, r)
+
+	pr, err := reader.NewParquetReader(r, new(Student), 2)
+	if err != nil {
+		t.Fatalf("reader.NewParquetReader: %v", err)
+	}
+	defer pr.ReadStop()
+
+	if n := pr.GetNumRows(); n != numRows {
+		t.Fatalf("expected %d rows but got %d", numRows, n)
+	}
+	got := make([]Student, numRows)
+	if err := pr.Read(&got); err != nil {
+		t.Fatalf("pr.Read: %v", err)
+	}
+	if len(got) != numRows {
+		t.Fatalf("expected to read %d rows but got %d", numRows, len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected row %d to be %+v but got %+v", i, want[i], got[i])
+		}
+	}
+}
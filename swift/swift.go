@@ -1,6 +1,11 @@
 package swiftsource
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
 	"github.com/ncw/swift"
 	"github.com/pkg/errors"
 	"github.com/sabey/parquet-go/source"
@@ -14,6 +19,8 @@ type SwiftFile struct {
 
 	FileReader *swift.ObjectOpenFile
 	FileWriter *swift.ObjectCreateFile
+
+	size int64
 }
 
 func newSwiftFile(containerName string, filePath string, conn *swift.Connection) *SwiftFile {
@@ -51,12 +58,18 @@ func (file *SwiftFile) Open(name string) (source.ParquetFile, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "file.Connection.ObjectOpen")
 	}
+	size, err := fr.Length()
+	if err != nil {
+		fr.Close()
+		return nil, errors.Wrap(err, "fr.Length")
+	}
 
 	res := &SwiftFile{
 		Connection: file.Connection,
 		Container:  file.Container,
 		FilePath:   name,
 		FileReader: fr,
+		size:       size,
 	}
 
 	return res, nil
@@ -82,16 +95,107 @@ func (file *SwiftFile) Create(name string) (source.ParquetFile, error) {
 	return res, nil
 }
 
+// Read fills b unless the end of the object is reached, io.EOF is only
+// returned when nothing was left to read
 func (file *SwiftFile) Read(b []byte) (n int, err error) {
-	n, err = file.FileReader.Read(b)
+	n, err = io.ReadFull(file.FileReader, b)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
 	if err != nil {
-		return n, errors.Wrap(err, "file.FileReader.Read")
+		return n, errors.Wrap(err, "io.ReadFull")
 	}
 	return n, nil
 }
 
+// ReadAt reads len(b) bytes starting at off with a ranged request of its
+// own, so it does not move the offset used by Read and is safe for
+// concurrent use
+func (file *SwiftFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: invalid offset")
+	}
+	if off >= file.size {
+		return 0, errors.Wrap(io.EOF, "io.EOF")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	end := off + int64(len(b)) - 1
+	if end >= file.size {
+		end = file.size - 1
+	}
+	headers := swift.Headers{"Range": fmt.Sprintf("bytes=%d-%d", off, end)}
+	fr, respHeaders, err := file.Connection.ObjectOpen(file.Container, file.FilePath, false, headers)
+	if err != nil {
+		return 0, errors.Wrap(err, "file.Connection.ObjectOpen")
+	}
+	defer fr.Close()
+
+	// ObjectOpen accepts any 2xx status: a 206 carries the Content-Range it
+	// returned, a 200 as long as the object ignored the range. Some servers
+	// answer the range with a 200 without Content-Range.
+	if contentRange, ok := respHeaders["Content-Range"]; ok {
+		var start int64
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-", &start); err != nil {
+			return 0, errors.Wrapf(err, "invalid Content-Range %q", contentRange)
+		}
+		if start != off {
+			return 0, fmt.Errorf("swift object [%s/%s] returned Content-Range %s for range %s", file.Container, file.FilePath, contentRange, headers["Range"])
+		}
+	} else if length, _ := strconv.ParseInt(respHeaders["Content-Length"], 10, 64); length == file.size {
+		// the range was ignored, skip to off
+		if _, err := io.CopyN(ioutil.Discard, fr, off); err != nil {
+			return 0, errors.Wrap(err, "io.CopyN")
+		}
+	}
+
+	n, err := io.ReadFull(fr, b[:end-off+1])
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		return n, errors.Wrap(err, "io.ReadFull")
+	}
+	if n < len(b) {
+		return n, errors.Wrap(io.EOF, "io.EOF")
+	}
+	return n, nil
+}
+
+// Seek moves the offset of the next Read and returns it. Offsets before the
+// start of the object are rejected, offsets at or past its end leave the
+// file at EOF.
 func (file *SwiftFile) Seek(offset int64, whence int) (int64, error) {
-	n, err := file.FileReader.Seek(offset, whence)
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		// Seek(0, io.SeekCurrent) only returns the offset
+		pos, err := file.FileReader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return pos, errors.Wrap(err, "file.FileReader.Seek")
+		}
+		offset += pos
+	case io.SeekEnd:
+		offset += file.size
+	default:
+		return 0, errors.New("Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("Seek: invalid offset")
+	}
+
+	// the object is reopened with a Range from offset, which cannot be
+	// requested at the end of the object: seeking from the end marks it
+	// as read instead
+	var n int64
+	var err error
+	if offset >= file.size {
+		n, err = file.FileReader.Seek(offset-file.size, io.SeekEnd)
+	} else {
+		n, err = file.FileReader.Seek(offset, io.SeekStart)
+	}
 	if err != nil {
 		return n, errors.Wrap(err, "file.FileReader.Seek")
 	}
@@ -0,0 +1,55 @@
+package swiftsource
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ncw/swift"
+	"github.com/ncw/swift/swifttest"
+	"github.com/sabey/parquet-go-source/sourcetest"
+	"github.com/sabey/parquet-go/source"
+)
+
+const container = "test-container"
+
+func newTestConnection(t *testing.T) *swift.Connection {
+	server, err := swifttest.NewSwiftServer("localhost")
+	if err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	t.Cleanup(server.Close)
+
+	conn := &swift.Connection{
+		UserName:    swifttest.TEST_ACCOUNT,
+		ApiKey:      swifttest.TEST_ACCOUNT,
+		AuthUrl:     server.AuthURL,
+		AuthVersion: 1,
+	}
+	if err := conn.Authenticate(); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	if err := conn.ContainerCreate(container, nil); err != nil {
+		t.Fatalf("expected error to be nil but got %q", err.Error())
+	}
+	return conn
+}
+
+func TestConformance(t *testing.T) {
+	conn := newTestConnection(t)
+	var files int
+	newName := func(t *testing.T) string {
+		files++
+		return fmt.Sprintf("conformance/%d.parquet", files)
+	}
+
+	sourcetest.Run(t, sourcetest.Harness{
+		NewWriter: func(t *testing.T) source.ParquetFile {
+			pf, err := NewSwiftFileWriter(container, newName(t), conn)
+			if err != nil {
+				t.Fatalf("expected error to be nil but got %q", err.Error())
+			}
+			return pf
+		},
+		NewName: newName,
+	})
+}
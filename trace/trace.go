@@ -0,0 +1,94 @@
+// Package trace lets the remote backends report their network operations to
+// a tracing system. The backends call Start around every remote operation
+// (HEAD, ranged GET, upload part, ...); nothing is recorded until a Tracer is
+// installed with SetTracer.
+package trace
+
+import (
+	"context"
+	"sync"
+)
+
+// Attribute keys set by the backends
+const (
+	AttrBackend = "parquet.backend"
+	AttrBucket  = "parquet.bucket"
+	AttrKey     = "parquet.key"
+	// AttrRange is the requested byte range, in the HTTP Range header format
+	AttrRange = "parquet.range"
+	// AttrBytes is the number of bytes transferred
+	AttrBytes = "parquet.bytes"
+	// AttrPart is the part number of a multipart upload
+	AttrPart = "parquet.part"
+	// AttrStatusCode is the HTTP status of the response
+	AttrStatusCode = "parquet.status_code"
+)
+
+// Attribute is a key value pair attached to a Span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string Attribute
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an integer Attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a single traced operation
+type Span interface {
+	// SetAttributes adds attributes learnt during the operation, such as the
+	// number of bytes transferred
+	SetAttributes(attrs ...Attribute)
+	// End finishes the span, err is the outcome of the operation
+	End(err error)
+}
+
+// Tracer starts spans, it is typically a thin adapter over an OpenTelemetry
+// tracer
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+var (
+	tracerLock sync.RWMutex
+	tracer     Tracer = noopTracer{}
+)
+
+// SetTracer installs the Tracer used by every backend, nil disables tracing
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracerLock.Lock()
+	tracer = t
+	tracerLock.Unlock()
+}
+
+// Start starts a span with the installed Tracer
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tracerLock.RLock()
+	t := tracer
+	tracerLock.RUnlock()
+	return t.Start(ctx, name, attrs...)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) End(error) {}
@@ -0,0 +1,42 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sabey/parquet-go-source/trace"
+	"github.com/sabey/parquet-go-source/trace/tracetest"
+)
+
+func TestSetTracer(t *testing.T) {
+	// the default tracer records nothing
+	ctx := context.Background()
+	got, span := trace.Start(ctx, "noop")
+	if got != ctx {
+		t.Error("expected the noop tracer to return the parent context")
+	}
+	span.End(nil)
+
+	recorder := tracetest.NewRecorder()
+	trace.SetTracer(recorder)
+	_, span = trace.Start(ctx, "op", trace.String(trace.AttrKey, "key"))
+	span.SetAttributes(trace.Int64(trace.AttrBytes, 42))
+	failed := errors.New("failed")
+	span.End(failed)
+
+	trace.SetTracer(nil)
+	_, span = trace.Start(ctx, "after reset")
+	span.End(nil)
+
+	spans := recorder.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span but got %v", recorder.Names())
+	}
+	if spans[0].Name != "op" || spans[0].Err != failed {
+		t.Errorf("expected a failed op span but got %s (%v)", spans[0].Name, spans[0].Err)
+	}
+	if spans[0].Attributes[trace.AttrKey] != "key" || spans[0].Attributes[trace.AttrBytes] != int64(42) {
+		t.Errorf("expected the span attributes to be recorded but got %v", spans[0].Attributes)
+	}
+}
@@ -0,0 +1,82 @@
+// Package tracetest provides a Tracer that records spans in memory for tests.
+package tracetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sabey/parquet-go-source/trace"
+)
+
+// Span is a finished span
+type Span struct {
+	Name       string
+	Attributes map[string]interface{}
+	Err        error
+}
+
+// Recorder is a trace.Tracer keeping every finished span
+type Recorder struct {
+	lock  sync.Mutex
+	spans []Span
+}
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start implements trace.Tracer
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...trace.Attribute) (context.Context, trace.Span) {
+	s := &span{recorder: r, name: name, attributes: map[string]interface{}{}}
+	s.SetAttributes(attrs...)
+	return ctx, s
+}
+
+// Spans returns the finished spans in the order they ended
+func (r *Recorder) Spans() []Span {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Span(nil), r.spans...)
+}
+
+// Names returns the names of the finished spans
+func (r *Recorder) Names() []string {
+	var names []string
+	for _, s := range r.Spans() {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// Reset drops the recorded spans
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	r.spans = nil
+	r.lock.Unlock()
+}
+
+type span struct {
+	recorder   *Recorder
+	name       string
+	lock       sync.Mutex
+	attributes map[string]interface{}
+}
+
+func (s *span) SetAttributes(attrs ...trace.Attribute) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, attr := range attrs {
+		s.attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *span) End(err error) {
+	s.lock.Lock()
+	finished := Span{Name: s.name, Attributes: s.attributes, Err: err}
+	s.lock.Unlock()
+
+	s.recorder.lock.Lock()
+	s.recorder.spans = append(s.recorder.spans, finished)
+	s.recorder.lock.Unlock()
+}